@@ -6,38 +6,126 @@ import (
 )
 
 const (
-	LogLevel               = "LOG_LEVEL"
-	CheckInterval          = "CHECK_INTERVAL"
-	DryRun                 = "DRY_RUN"
-	ContextTestingKey      = "CONTEXT_TESTING_KEY"
-	ContextFakeObjectsKey  = "CONTEXT_FAKE_OBJECTS_KEY"
-	KubeconfigPath         = "KUBECONFIG_PATH"
-	ResourceLabelKey       = "RESOURCE_LABEL_KEY"
-	ResourceLabelValue     = "RESOURCE_LABEL_VALUE"
-	ClientTimeout          = "CLIENT_TIMEOUT"
-	IngressHostAnnotation  = "INGRESS_HOST_ANNOTATION"
-	IngressClassAnnotation = "INGRESS_CLASS_ANNOTATION"
-	IngressPathAnnotation  = "INGRESS_PATH_ANNOTATION"
-	IngressEnableTLS       = "INGRESS_ENABLE_TLS"
-	IngressAnnotations     = "INGRESS_ANNOTATIONS"
-	IngressLabels          = "INGRESS_LABELS"
-	IngressPathType        = "INGRESS_PATH_TYPE"
+	LogLevel                   = "LOG_LEVEL"
+	HTTPAddress                = "HTTP_ADDRESS"
+	CheckInterval              = "CHECK_INTERVAL"
+	HealthIntervalMultiplier   = "HEALTH_INTERVAL_MULTIPLIER"
+	DryRun                     = "DRY_RUN"
+	ContextTestingKey          = "CONTEXT_TESTING_KEY"
+	ContextFakeObjectsKey      = "CONTEXT_FAKE_OBJECTS_KEY"
+	KubeconfigPath             = "KUBECONFIG_PATH"
+	ResourceLabelKey           = "RESOURCE_LABEL_KEY"
+	ResourceLabelValue         = "RESOURCE_LABEL_VALUE"
+	InstanceName               = "INSTANCE_NAME"
+	InstanceLabelKey           = "INSTANCE_LABEL_KEY"
+	InstanceClasses            = "INSTANCE_CLASSES"
+	InstanceDefaultClass       = "INSTANCE_DEFAULT_CLASS"
+	ServiceSelector            = "SERVICE_SELECTOR"
+	IngressSelector            = "INGRESS_SELECTOR"
+	WatchNamespaces            = "WATCH_NAMESPACES"
+	ExcludeNamespaces          = "EXCLUDE_NAMESPACES"
+	NamespaceSelector          = "NAMESPACE_SELECTOR"
+	ClientTimeout              = "CLIENT_TIMEOUT"
+	RetryBaseDelay             = "RETRY_BASE_DELAY"
+	RetryMaxDelay              = "RETRY_MAX_DELAY"
+	LeaderElection             = "LEADER_ELECTION"
+	LeaseName                  = "LEASE_NAME"
+	LeaseNamespace             = "LEASE_NAMESPACE"
+	LeaseDuration              = "LEASE_DURATION"
+	LeaseRenewDeadline         = "LEASE_RENEW_DEADLINE"
+	LeaseRetryPeriod           = "LEASE_RETRY_PERIOD"
+	IngressHostAnnotation      = "INGRESS_HOST_ANNOTATION"
+	IngressClassAnnotation     = "INGRESS_CLASS_ANNOTATION"
+	IngressPathAnnotation      = "INGRESS_PATH_ANNOTATION"
+	IngressPortAnnotation      = "INGRESS_PORT_ANNOTATION"
+	IngressProtocolAnnotation  = "INGRESS_PROTOCOL_ANNOTATION"
+	IngressIssuerAnnotation    = "INGRESS_ISSUER_ANNOTATION"
+	IngressTLSAnnotation       = "INGRESS_TLS_ANNOTATION"
+	IngressTLSSecretAnnotation = "INGRESS_TLS_SECRET_ANNOTATION"
+	IngressNameAnnotation      = "INGRESS_NAME_ANNOTATION"
+	IngressOwnersAnnotation    = "INGRESS_OWNERS_ANNOTATION"
+	IngressManagedAnnotation   = "INGRESS_MANAGED_ANNOTATION"
+	IngressAdoptAnnotation     = "INGRESS_ADOPT_ANNOTATION"
+	IngressAdoptionPolicy      = "INGRESS_ADOPTION_POLICY"
+	IngressEnableTLS           = "INGRESS_ENABLE_TLS"
+	IngressTLSSecretTemplate   = "INGRESS_TLS_SECRET_TEMPLATE"
+	IngressWildcardTLSTemplate = "INGRESS_WILDCARD_TLS_TEMPLATE"
+	IngressNameTemplate        = "INGRESS_NAME_TEMPLATE"
+	IngressAnnotations         = "INGRESS_ANNOTATIONS"
+	IngressLabels              = "INGRESS_LABELS"
+	IngressAnnotationPrefix    = "INGRESS_ANNOTATION_PREFIX"
+	IngressLabelPrefix         = "INGRESS_LABEL_PREFIX"
+	IngressAnnotationAllowlist = "INGRESS_ANNOTATION_ALLOWLIST"
+	IngressAnnotationDenylist  = "INGRESS_ANNOTATION_DENYLIST"
+	IngressPathType            = "INGRESS_PATH_TYPE"
+	CertManagerIssuer          = "CERT_MANAGER_ISSUER"
+	CertManagerIssuerKind      = "CERT_MANAGER_ISSUER_KIND"
+	CertManagerCertificates    = "CERT_MANAGER_CERTIFICATES"
+	OutputMode                 = "OUTPUT_MODE"
+	GatewayName                = "GATEWAY_NAME"
+	GatewayNamespace           = "GATEWAY_NAMESPACE"
+	GatewaySectionName         = "GATEWAY_SECTION_NAME"
+	GatewayRouteKinds          = "GATEWAY_ROUTE_KINDS"
+	RouteIngressAnnotation     = "ROUTE_INGRESS_ANNOTATION"
+)
+
+const (
+	OutputModeIngress = "ingress"
+	OutputModeGateway = "gateway"
+)
+
+const (
+	AdoptionPolicyRefuse     = "refuse"
+	AdoptionPolicyAnnotation = "annotation"
+	AdoptionPolicyForce      = "force"
 )
 
 var defaults = map[string]string{
-	LogLevel:               "info",
-	CheckInterval:          "30",
-	DryRun:                 "false",
-	ContextTestingKey:      "testing",
-	ContextFakeObjectsKey:  "fake_objects",
-	ClientTimeout:          "60",
-	ResourceLabelKey:       "ptonini.github.io/ingress-bot",
-	ResourceLabelValue:     "true",
-	IngressHostAnnotation:  "ptonini.github.io/ingress-host",
-	IngressClassAnnotation: "ptonini.github.io/ingress-class",
-	IngressPathAnnotation:  "ptonini.github.io/ingress-path",
-	IngressEnableTLS:       "true",
-	IngressPathType:        "ImplementationSpecific",
+	LogLevel:                   "info",
+	HTTPAddress:                ":8080",
+	CheckInterval:              "30",
+	HealthIntervalMultiplier:   "3",
+	DryRun:                     "false",
+	ContextTestingKey:          "testing",
+	ContextFakeObjectsKey:      "fake_objects",
+	ClientTimeout:              "60",
+	RetryBaseDelay:             "1",
+	RetryMaxDelay:              "300",
+	LeaderElection:             "false",
+	LeaseName:                  "ingress-bot",
+	LeaseNamespace:             "default",
+	LeaseDuration:              "15",
+	LeaseRenewDeadline:         "10",
+	LeaseRetryPeriod:           "2",
+	ResourceLabelKey:           "ptonini.github.io/ingress-bot",
+	ResourceLabelValue:         "true",
+	InstanceLabelKey:           "ptonini.github.io/ingress-bot-instance",
+	IngressHostAnnotation:      "ptonini.github.io/ingress-host",
+	IngressClassAnnotation:     "ptonini.github.io/ingress-class",
+	IngressPathAnnotation:      "ptonini.github.io/ingress-path",
+	IngressPortAnnotation:      "ptonini.github.io/ingress-port",
+	IngressProtocolAnnotation:  "ptonini.github.io/ingress-protocol",
+	IngressIssuerAnnotation:    "ptonini.github.io/ingress-issuer",
+	IngressTLSAnnotation:       "ptonini.github.io/ingress-tls",
+	IngressTLSSecretAnnotation: "ptonini.github.io/ingress-tls-secret",
+	IngressNameAnnotation:      "ptonini.github.io/ingress-name",
+	IngressOwnersAnnotation:    "ptonini.github.io/ingress-owners",
+	IngressManagedAnnotation:   "ptonini.github.io/ingress-managed-keys",
+	IngressAdoptAnnotation:     "ptonini.github.io/ingress-adopt",
+	IngressAdoptionPolicy:      AdoptionPolicyRefuse,
+	IngressEnableTLS:           "true",
+	IngressTLSSecretTemplate:   "{{ .Name }}-tls",
+	IngressWildcardTLSTemplate: "{{ .Domain }}-wildcard-tls",
+	IngressNameTemplate:        "{{ .Host }}",
+	IngressAnnotationPrefix:    "annotation.ingress.ptonini.github.io.",
+	IngressLabelPrefix:         "label.ingress.ptonini.github.io.",
+	IngressAnnotationDenylist:  ".*-snippet",
+	IngressPathType:            "ImplementationSpecific",
+	CertManagerIssuerKind:      "ClusterIssuer",
+	CertManagerCertificates:    "false",
+	OutputMode:                 OutputModeIngress,
+	GatewayRouteKinds:          "HTTPRoute",
+	RouteIngressAnnotation:     "ptonini.github.io/route-ingress",
 }
 
 var LogLevels = map[string]zapcore.Level{
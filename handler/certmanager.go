@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"maps"
+	"reflect"
+	"strings"
+)
+
+const (
+	issuerKind        = "Issuer"
+	clusterIssuerKind = "ClusterIssuer"
+)
+
+var issuerAnnotations = map[string]string{
+	issuerKind:        "cert-manager.io/issuer",
+	clusterIssuerKind: "cert-manager.io/cluster-issuer",
+}
+
+// getServiceIssuer reads the issuer annotation as [<kind>/]<name>, falling back to the global issuer.
+// The issuer is returned as <kind>/<name>, or empty when there is none.
+func (h *Handler) getServiceIssuer(s *core.Service) (string, error) {
+	v := s.Annotations[viper.GetString(config.IngressIssuerAnnotation)]
+	if v == "" {
+		v = viper.GetString(config.CertManagerIssuer)
+	}
+	if v == "" {
+		return "", nil
+	}
+	kind, name, ok := strings.Cut(v, "/")
+	if !ok {
+		kind, name = viper.GetString(config.CertManagerIssuerKind), v
+	}
+	if _, ok := issuerAnnotations[kind]; !ok || name == "" {
+		return "", fmt.Errorf("service %s/%s has invalid issuer %s", s.Namespace, s.Name, v)
+	}
+	return kind + "/" + name, nil
+}
+
+// setIngressIssuer records the issuer on the ingress, adding the cert-manager annotations
+// unless the bot manages the certificates itself
+func (h *Handler) setIngressIssuer(i *networking.Ingress, issuer string) {
+	if issuer == "" || len(i.Spec.TLS) == 0 {
+		return
+	}
+	i.Annotations[viper.GetString(config.IngressIssuerAnnotation)] = issuer
+	if !viper.GetBool(config.CertManagerCertificates) {
+		kind, name, _ := strings.Cut(issuer, "/")
+		i.Annotations[issuerAnnotations[kind]] = name
+	}
+}
+
+// certificateIngressKey returns the key of the ingress owning a certificate
+func certificateIngressKey(c *unstructured.Unstructured) string {
+	for _, o := range c.GetOwnerReferences() {
+		if o.Kind == "Ingress" {
+			return cache.NewObjectName(c.GetNamespace(), o.Name).String()
+		}
+	}
+	return cache.NewObjectName(c.GetNamespace(), c.GetName()).String()
+}
+
+func (h *Handler) fetchCertificates(key string) (map[string]*unstructured.Unstructured, error) {
+	l, err := h.certificateLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching certificates: %v", err)
+	}
+	list := map[string]*unstructured.Unstructured{}
+	for _, o := range l {
+		if c, ok := o.(*unstructured.Unstructured); ok && certificateIngressKey(c) == key {
+			list[cache.NewObjectName(c.GetNamespace(), c.GetName()).String()] = c
+		}
+	}
+	return list, nil
+}
+
+// buildCertificates creates a certificate for each tls entry of the ingress, named after its secret,
+// leaving out existing secrets declared by the services
+func (h *Handler) buildCertificates(i *networking.Ingress) map[string]*unstructured.Unstructured {
+	certificates := map[string]*unstructured.Unstructured{}
+	kind, name, ok := strings.Cut(i.Annotations[viper.GetString(config.IngressIssuerAnnotation)], "/")
+	if !ok {
+		return certificates
+	}
+	ownersKey := viper.GetString(config.IngressOwnersAnnotation)
+	for _, t := range i.Spec.TLS {
+		if isExistingTLSSecret(i, t.SecretName) {
+			continue
+		}
+		var dnsNames []interface{}
+		for _, host := range t.Hosts {
+			dnsNames = append(dnsNames, host)
+		}
+		c := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"secretName": t.SecretName,
+				"dnsNames":   dnsNames,
+				"issuerRef": map[string]interface{}{
+					"group": "cert-manager.io",
+					"kind":  kind,
+					"name":  name,
+				},
+			},
+		}}
+		c.SetAPIVersion("cert-manager.io/v1")
+		c.SetKind("Certificate")
+		c.SetName(t.SecretName)
+		c.SetNamespace(i.Namespace)
+		c.SetLabels(maps.Clone(i.Labels))
+		c.SetAnnotations(map[string]string{ownersKey: i.Annotations[ownersKey]})
+		setManagedKeys(c)
+		c.SetOwnerReferences([]meta.OwnerReference{{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+			Name:       i.Name,
+			UID:        i.UID,
+		}})
+		certificates[cache.NewObjectName(i.Namespace, t.SecretName).String()] = c
+	}
+	return certificates
+}
+
+func (h *Handler) compareCertificates(d *unstructured.Unstructured, c *unstructured.Unstructured) (specsAreEqual bool) {
+
+	if !h.compareMeta("certificate", d, c) {
+		return
+	}
+
+	specsAreEqual = reflect.DeepEqual(d.Object["spec"], c.Object["spec"])
+	if !specsAreEqual {
+		h.logger.Debug(fmt.Sprintf("updated spec on certificate %s", c.GetName()))
+	}
+	return
+}
+
+func (h *Handler) createCertificate(c *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	h.logger.Info(fmt.Sprintf("creating certificate %s", c.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	c, err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace(c.GetNamespace()).Create(ctx, c, meta.CreateOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("create").Inc()
+		return nil, fmt.Errorf("error creating certificate: %v", err)
+	}
+	metrics.CertificateOperations.WithLabelValues("create").Inc()
+	h.recorder.Event(c, core.EventTypeNormal, "Created", "certificate created for ingress tls")
+	return c, nil
+}
+
+func (h *Handler) updateCertificate(c *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	h.logger.Info(fmt.Sprintf("updating certificate %s", c.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	c, err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace(c.GetNamespace()).Update(ctx, c, meta.UpdateOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("update").Inc()
+		return nil, fmt.Errorf("error updating certificate: %v", err)
+	}
+	metrics.CertificateOperations.WithLabelValues("update").Inc()
+	h.recorder.Event(c, core.EventTypeNormal, "Updated", "certificate updated for ingress tls")
+	return c, nil
+}
+
+func (h *Handler) deleteCertificate(c *unstructured.Unstructured) error {
+	h.logger.Info(fmt.Sprintf("deleting certificate %s", c.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace(c.GetNamespace()).Delete(ctx, c.GetName(), meta.DeleteOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("delete").Inc()
+		return fmt.Errorf("error deleting certificate %s/%s: %v", c.GetNamespace(), c.GetName(), err)
+	}
+	metrics.CertificateOperations.WithLabelValues("delete").Inc()
+	h.recorder.Event(c, core.EventTypeNormal, "Deleted", "certificate deleted, no ingress tls left")
+	return nil
+}
+
+// reconcileCertificates runs after the ingresses are applied, since certificates are owned by them
+func (h *Handler) reconcileCertificates(key string) error {
+
+	var errs []error
+
+	current, err := h.fetchCertificates(key)
+	if err != nil {
+		return err
+	}
+	desired := map[string]*unstructured.Unstructured{}
+	for k := range h.desiredIngresses {
+		if i, ok := h.currentIngresses[k]; ok {
+			maps.Copy(desired, h.buildCertificates(i))
+		}
+	}
+
+	// Remove undesired certificates, as long as the bot created them
+	for k, c := range current {
+		if _, ok := desired[k]; !ok {
+			if !isOwnedObject(c) {
+				h.logger.Debug(fmt.Sprintf("skipping deletion of unowned certificate %s", k))
+				continue
+			}
+			if err := h.deleteCertificate(c); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// Upsert desired certificates
+	for k, c := range desired {
+		if o, ok := current[k]; ok {
+			// Custom resources can't be updated without the current resource version
+			if !h.compareCertificates(c, o) {
+				c.SetResourceVersion(o.GetResourceVersion())
+				if _, err := h.updateCertificate(c); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		} else {
+			if _, err := h.createCertificate(c); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Handler) enqueueCertificate(obj interface{}) {
+	if t, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = t.Obj
+	}
+	if c, ok := obj.(*unstructured.Unstructured); ok {
+		h.queue.Add(certificateIngressKey(c))
+	}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	k8sTesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"testing"
+)
+
+func newCertificate(name string, ingressName string) *unstructured.Unstructured {
+	c := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+	c.SetAPIVersion("cert-manager.io/v1")
+	c.SetKind("Certificate")
+	c.SetName(name)
+	c.SetNamespace("default")
+	c.SetLabels(map[string]string{viper.GetString(config.ResourceLabelKey): viper.GetString(config.ResourceLabelValue)})
+	c.SetOwnerReferences([]meta.OwnerReference{{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: ingressName}})
+	return c
+}
+
+func certificateErrorReactor(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+	return true, nil, errors.New("fake error")
+}
+
+func Test_CertManager(t *testing.T) {
+
+	config.Load()
+	viper.Set(config.DryRun, "true")
+	viper.Set(config.CertManagerIssuer, "letsencrypt")
+	defer viper.Set(config.CertManagerIssuer, "")
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, _ := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	h := newTestHandler(t, ctx, logger)
+
+	t.Run("get service issuer", func(t *testing.T) {
+		s := service.DeepCopy()
+		issuer, err := h.getServiceIssuer(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "ClusterIssuer/letsencrypt", issuer)
+		s.Annotations[viper.GetString(config.IngressIssuerAnnotation)] = "Issuer/internal"
+		issuer, err = h.getServiceIssuer(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "Issuer/internal", issuer)
+		s.Annotations[viper.GetString(config.IngressIssuerAnnotation)] = "Vault/internal"
+		_, err = h.getServiceIssuer(s)
+		assert.Error(t, err)
+	})
+	t.Run("get service issuer without default", func(t *testing.T) {
+		viper.Set(config.CertManagerIssuer, "")
+		defer viper.Set(config.CertManagerIssuer, "letsencrypt")
+		issuer, err := h.getServiceIssuer(service.DeepCopy())
+		assert.NoError(t, err)
+		assert.Equal(t, "", issuer)
+	})
+
+	t.Run("set ingress issuer", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", true)
+		h.setIngressIssuer(i, "ClusterIssuer/letsencrypt")
+		assert.Equal(t, "letsencrypt", i.Annotations["cert-manager.io/cluster-issuer"])
+		assert.Equal(t, "ClusterIssuer/letsencrypt", i.Annotations[viper.GetString(config.IngressIssuerAnnotation)])
+	})
+	t.Run("set ingress issuer with managed certificates", func(t *testing.T) {
+		viper.Set(config.CertManagerCertificates, true)
+		defer viper.Set(config.CertManagerCertificates, false)
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", true)
+		h.setIngressIssuer(i, "Issuer/internal")
+		assert.NotContains(t, i.Annotations, "cert-manager.io/issuer")
+		assert.Equal(t, "Issuer/internal", i.Annotations[viper.GetString(config.IngressIssuerAnnotation)])
+	})
+	t.Run("set ingress issuer without tls", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", false)
+		h.setIngressIssuer(i, "ClusterIssuer/letsencrypt")
+		assert.NotContains(t, i.Annotations, "cert-manager.io/cluster-issuer")
+	})
+
+	t.Run("build desired ingresses with issuer conflict", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressIssuerAnnotation)] = "Issuer/internal"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Equal(t, "letsencrypt", l["default/www-example-com"].Annotations["cert-manager.io/cluster-issuer"])
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring issuer Issuer/internal")
+	})
+
+	t.Run("build certificates", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com", "www2.example.com"}, "", true)
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h.setIngressIssuer(i, "ClusterIssuer/letsencrypt")
+		l := h.buildCertificates(i)
+		assert.Len(t, l, 1)
+		c := l["default/www-example-com-tls"]
+		dnsNames, _, _ := unstructured.NestedStringSlice(c.Object, "spec", "dnsNames")
+		assert.Equal(t, []string{"www.example.com", "www2.example.com"}, dnsNames)
+		issuerKind, _, _ := unstructured.NestedString(c.Object, "spec", "issuerRef", "kind")
+		assert.Equal(t, "ClusterIssuer", issuerKind)
+		assert.Equal(t, "default/www-example-com", certificateIngressKey(c))
+		assert.Equal(t, "service", c.GetAnnotations()[viper.GetString(config.IngressOwnersAnnotation)])
+	})
+	t.Run("build certificates with existing secret", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com", "www.example.org"}, "", true)
+		h.setIngressIssuer(i, "ClusterIssuer/letsencrypt")
+		h.setIngressTLSSecrets(i, map[string]string{"www.example.com": "wildcard-example-com"})
+		l := h.buildCertificates(i)
+		assert.Len(t, l, 1)
+		assert.Contains(t, l, "default/www-example-com-tls")
+	})
+	t.Run("build certificates without issuer", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", true)
+		assert.Len(t, h.buildCertificates(i), 0)
+	})
+
+	t.Run("compare certificates", func(t *testing.T) {
+		cur := newCertificate("www-example-com-tls", "www-example-com")
+		des := cur.DeepCopy()
+		assert.True(t, h.compareCertificates(des, cur))
+		_ = unstructured.SetNestedField(des.Object, "www-tls", "spec", "secretName")
+		assert.False(t, h.compareCertificates(des, cur))
+	})
+
+	viper.Set(config.CertManagerCertificates, true)
+	defer viper.Set(config.CertManagerCertificates, false)
+
+	t.Run("fetch certificates", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, newCertificate("www-example-com-tls", "www-example-com"), newCertificate("other-tls", "other"))
+		l, err := h.fetchCertificates("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+	})
+
+	t.Run("create certificate", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		count := testutil.ToFloat64(metrics.CertificateOperations.WithLabelValues("create"))
+		_, err := h.createCertificate(newCertificate("www-example-com-tls", "www-example-com"))
+		assert.NoError(t, err)
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.CertificateOperations.WithLabelValues("create")))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Created")
+	})
+	t.Run("create certificate with error", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		kube.DynamicClient.(*dynamicFake.FakeDynamicClient).PrependReactor("create", "certificates", certificateErrorReactor)
+		_, err := h.createCertificate(newCertificate("www-example-com-tls", "www-example-com"))
+		assert.Error(t, err)
+	})
+	t.Run("update certificate", func(t *testing.T) {
+		c := newCertificate("www-example-com-tls", "www-example-com")
+		h := newTestHandler(t, ctx, logger, c)
+		_, err := h.updateCertificate(c.DeepCopy())
+		assert.NoError(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
+	})
+	t.Run("delete certificate", func(t *testing.T) {
+		c := newCertificate("www-example-com-tls", "www-example-com")
+		h := newTestHandler(t, ctx, logger, c)
+		assert.NoError(t, h.deleteCertificate(c.DeepCopy()))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Deleted")
+	})
+	t.Run("delete certificate with error", func(t *testing.T) {
+		c := newCertificate("www-example-com-tls", "www-example-com")
+		h := newTestHandler(t, ctx, logger, c)
+		kube.DynamicClient.(*dynamicFake.FakeDynamicClient).PrependReactor("delete", "certificates", certificateErrorReactor)
+		assert.Error(t, h.deleteCertificate(c.DeepCopy()))
+	})
+
+	t.Run("reconcile creating certificate", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy())
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		i, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NotContains(t, i.Annotations, "cert-manager.io/cluster-issuer")
+		c, err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace("default").Get(ctx, "www-example-com-tls", meta.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "www-example-com", c.GetOwnerReferences()[0].Name)
+	})
+	t.Run("reconcile updating certificate", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), newCertificate("www-example-com-tls", "www-example-com"))
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		c, _ := kube.DynamicClient.Resource(kube.CertificateResource).Namespace("default").Get(ctx, "www-example-com-tls", meta.GetOptions{})
+		secretName, _, _ := unstructured.NestedString(c.Object, "spec", "secretName")
+		assert.Equal(t, "www-example-com-tls", secretName)
+	})
+	t.Run("reconcile deleting certificate", func(t *testing.T) {
+		c := newCertificate("www-example-com-old", "www-example-com")
+		c.SetAnnotations(map[string]string{viper.GetString(config.IngressOwnersAnnotation): "service"})
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), c)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		_, err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace("default").Get(ctx, "www-example-com-old", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+	t.Run("reconcile skipping unowned certificate", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), newCertificate("www-example-com-old", "www-example-com"))
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		_, err := kube.DynamicClient.Resource(kube.CertificateResource).Namespace("default").Get(ctx, "www-example-com-old", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("enqueue certificate", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		c := newCertificate("www-example-com-tls", "www-example-com")
+		h.enqueueCertificate(c)
+		h.enqueueCertificate(cache.DeletedFinalStateUnknown{Obj: c})
+		assert.Equal(t, 1, h.queue.Len())
+		key, _ := h.queue.Get()
+		assert.Equal(t, "default/www-example-com", key)
+	})
+
+}
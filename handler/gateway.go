@@ -0,0 +1,524 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"maps"
+	"reflect"
+	gatewayApi "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayAlpha "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	"slices"
+	"strings"
+)
+
+const (
+	protocolHTTP = "http"
+	protocolGRPC = "grpc"
+	protocolTLS  = "tls"
+)
+
+var protocolRouteKinds = map[string]string{
+	protocolHTTP: "HTTPRoute",
+	protocolGRPC: "GRPCRoute",
+	protocolTLS:  "TLSRoute",
+}
+
+type routeObject interface {
+	*gatewayApi.HTTPRoute | *gatewayAlpha.GRPCRoute | *gatewayAlpha.TLSRoute
+	meta.Object
+	runtime.Object
+}
+
+type routeClient[T routeObject] interface {
+	Create(ctx context.Context, r T, opts meta.CreateOptions) (T, error)
+	Update(ctx context.Context, r T, opts meta.UpdateOptions) (T, error)
+	Delete(ctx context.Context, name string, opts meta.DeleteOptions) error
+}
+
+// routeSet holds routes of every kind, keyed by namespace and name
+type routeSet struct {
+	http map[string]*gatewayApi.HTTPRoute
+	grpc map[string]*gatewayAlpha.GRPCRoute
+	tls  map[string]*gatewayAlpha.TLSRoute
+}
+
+func newRouteSet() routeSet {
+	return routeSet{
+		http: map[string]*gatewayApi.HTTPRoute{},
+		grpc: map[string]*gatewayAlpha.GRPCRoute{},
+		tls:  map[string]*gatewayAlpha.TLSRoute{},
+	}
+}
+
+func httpRouteClient(namespace string) routeClient[*gatewayApi.HTTPRoute] {
+	return kube.GatewayClientSet.GatewayV1().HTTPRoutes(namespace)
+}
+
+func grpcRouteClient(namespace string) routeClient[*gatewayAlpha.GRPCRoute] {
+	return kube.GatewayClientSet.GatewayV1alpha2().GRPCRoutes(namespace)
+}
+
+func tlsRouteClient(namespace string) routeClient[*gatewayAlpha.TLSRoute] {
+	return kube.GatewayClientSet.GatewayV1alpha2().TLSRoutes(namespace)
+}
+
+func routeSpec(r any) any {
+	switch r := r.(type) {
+	case *gatewayApi.HTTPRoute:
+		return r.Spec
+	case *gatewayAlpha.GRPCRoute:
+		return r.Spec
+	case *gatewayAlpha.TLSRoute:
+		return r.Spec
+	}
+	return nil
+}
+
+// routeIngressKey returns the key of the ingress a route was generated from
+func (h *Handler) routeIngressKey(r meta.Object) string {
+	name := r.GetAnnotations()[viper.GetString(config.RouteIngressAnnotation)]
+	if name == "" {
+		name = r.GetName()
+	}
+	return cache.NewObjectName(r.GetNamespace(), name).String()
+}
+
+func fetchRouteKind[T routeObject](h *Handler, lister lister[T], key string) (map[string]T, error) {
+	list := map[string]T{}
+	if lister == nil {
+		return list, nil
+	}
+	l, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching routes: %v", err)
+	}
+	for _, v := range l {
+		if h.routeIngressKey(v) == key {
+			list[cache.NewObjectName(v.GetNamespace(), v.GetName()).String()] = v
+		}
+	}
+	return list, nil
+}
+
+func (h *Handler) fetchRoutes(key string) (routes routeSet, err error) {
+	if routes.http, err = fetchRouteKind[*gatewayApi.HTTPRoute](h, h.httpRouteLister, key); err != nil {
+		return
+	}
+	if routes.grpc, err = fetchRouteKind[*gatewayAlpha.GRPCRoute](h, h.grpcRouteLister, key); err != nil {
+		return
+	}
+	routes.tls, err = fetchRouteKind[*gatewayAlpha.TLSRoute](h, h.tlsRouteLister, key)
+	return
+}
+
+func (h *Handler) listRoutes() []meta.Object {
+	var routes []meta.Object
+	if h.httpRouteLister != nil {
+		l, _ := h.httpRouteLister.List(labels.Everything())
+		for _, r := range l {
+			routes = append(routes, r)
+		}
+	}
+	if h.grpcRouteLister != nil {
+		l, _ := h.grpcRouteLister.List(labels.Everything())
+		for _, r := range l {
+			routes = append(routes, r)
+		}
+	}
+	if h.tlsRouteLister != nil {
+		l, _ := h.tlsRouteLister.List(labels.Everything())
+		for _, r := range l {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// getBackendPort resolves the number of an ingress backend port, since routes can't reference ports by name
+func (h *Handler) getBackendPort(namespace string, b *networking.IngressServiceBackend) (gatewayApi.PortNumber, error) {
+	if b.Port.Name == "" {
+		return gatewayApi.PortNumber(b.Port.Number), nil
+	}
+	if s, ok := h.services[cache.NewObjectName(namespace, b.Name).String()]; ok {
+		for _, p := range s.Spec.Ports {
+			if p.Name == b.Port.Name {
+				return gatewayApi.PortNumber(p.Port), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("service %s/%s has no port %s", namespace, b.Name, b.Port.Name)
+}
+
+func (h *Handler) getBackendProtocol(namespace string, b *networking.IngressServiceBackend) string {
+	s := h.services[cache.NewObjectName(namespace, b.Name).String()]
+	if p, err := h.getServiceProtocol(&s); err == nil {
+		return p
+	}
+	return protocolHTTP
+}
+
+func (h *Handler) getParentRefs() []gatewayApi.ParentReference {
+
+	// Defaulted fields are set explicitly, so routes read back from the api compare equal
+	group := gatewayApi.Group(gatewayApi.GroupName)
+	kind := gatewayApi.Kind("Gateway")
+	ref := gatewayApi.ParentReference{
+		Group: &group,
+		Kind:  &kind,
+		Name:  gatewayApi.ObjectName(viper.GetString(config.GatewayName)),
+	}
+	if v := viper.GetString(config.GatewayNamespace); v != "" {
+		namespace := gatewayApi.Namespace(v)
+		ref.Namespace = &namespace
+	}
+	if v := viper.GetString(config.GatewaySectionName); v != "" {
+		section := gatewayApi.SectionName(v)
+		ref.SectionName = &section
+	}
+	return []gatewayApi.ParentReference{ref}
+
+}
+
+func buildBackendRef(name string, port gatewayApi.PortNumber) gatewayApi.BackendRef {
+	group := gatewayApi.Group("")
+	kind := gatewayApi.Kind("Service")
+	weight := int32(1)
+	return gatewayApi.BackendRef{
+		BackendObjectReference: gatewayApi.BackendObjectReference{
+			Group: &group,
+			Kind:  &kind,
+			Name:  gatewayApi.ObjectName(name),
+			Port:  &port,
+		},
+		Weight: &weight,
+	}
+}
+
+func buildRouteMatch(p networking.HTTPIngressPath) gatewayApi.HTTPRouteMatch {
+	matchType := gatewayApi.PathMatchPathPrefix
+	if *p.PathType == networking.PathTypeExact {
+		matchType = gatewayApi.PathMatchExact
+	}
+	value := p.Path
+	if value == "" {
+		value = "/"
+	}
+	return gatewayApi.HTTPRouteMatch{
+		Path: &gatewayApi.HTTPPathMatch{Type: &matchType, Value: &value},
+	}
+}
+
+// buildGRPCRouteMatches reads paths as /<service>[/<method>], with an empty path matching every method
+func buildGRPCRouteMatches(p networking.HTTPIngressPath) []gatewayAlpha.GRPCRouteMatch {
+	service, method, _ := strings.Cut(strings.Trim(p.Path, "/"), "/")
+	if service == "" && method == "" {
+		return nil
+	}
+	matchType := gatewayAlpha.GRPCMethodMatchExact
+	m := &gatewayAlpha.GRPCMethodMatch{Type: &matchType}
+	if service != "" {
+		m.Service = &service
+	}
+	if method != "" {
+		m.Method = &method
+	}
+	return []gatewayAlpha.GRPCRouteMatch{{Method: m}}
+}
+
+// addHTTPRouteRule adds the path to the rule of its backend, so each backend gets a single rule
+func addHTTPRouteRule(rules []gatewayApi.HTTPRouteRule, ref gatewayApi.BackendRef, p networking.HTTPIngressPath) []gatewayApi.HTTPRouteRule {
+	j := slices.IndexFunc(rules, func(r gatewayApi.HTTPRouteRule) bool { return reflect.DeepEqual(r.BackendRefs[0].BackendRef, ref) })
+	if j < 0 {
+		j = len(rules)
+		rules = append(rules, gatewayApi.HTTPRouteRule{BackendRefs: []gatewayApi.HTTPBackendRef{{BackendRef: ref}}})
+	}
+	rules[j].Matches = append(rules[j].Matches, buildRouteMatch(p))
+	return rules
+}
+
+func addGRPCRouteRule(rules []gatewayAlpha.GRPCRouteRule, ref gatewayApi.BackendRef, p networking.HTTPIngressPath) []gatewayAlpha.GRPCRouteRule {
+	j := slices.IndexFunc(rules, func(r gatewayAlpha.GRPCRouteRule) bool { return reflect.DeepEqual(r.BackendRefs[0].BackendRef, ref) })
+	if j < 0 {
+		j = len(rules)
+		rules = append(rules, gatewayAlpha.GRPCRouteRule{BackendRefs: []gatewayAlpha.GRPCBackendRef{{BackendRef: ref}}})
+	}
+	rules[j].Matches = append(rules[j].Matches, buildGRPCRouteMatches(p)...)
+	return rules
+}
+
+// addTLSRouteRule adds the backend once, since passthrough routes can't match on paths
+func addTLSRouteRule(rules []gatewayAlpha.TLSRouteRule, ref gatewayApi.BackendRef) []gatewayAlpha.TLSRouteRule {
+	if slices.ContainsFunc(rules, func(r gatewayAlpha.TLSRouteRule) bool { return reflect.DeepEqual(r.BackendRefs[0], ref) }) {
+		return rules
+	}
+	return append(rules, gatewayAlpha.TLSRouteRule{BackendRefs: []gatewayApi.BackendRef{ref}})
+}
+
+type hostGroup[R any] struct {
+	hostnames []gatewayApi.Hostname
+	rules     []R
+}
+
+// groupHostRules groups hosts with the same rules, since route hostnames apply to every rule.
+// The first group is named after the ingress and the rest are numbered.
+func groupHostRules[R any](name string, hosts []string, rules [][]R) map[string]*hostGroup[R] {
+	var groups []*hostGroup[R]
+	for j, host := range hosts {
+		if len(rules[j]) == 0 {
+			continue
+		}
+		k := slices.IndexFunc(groups, func(g *hostGroup[R]) bool { return reflect.DeepEqual(g.rules, rules[j]) })
+		if k < 0 {
+			k = len(groups)
+			groups = append(groups, &hostGroup[R]{rules: rules[j]})
+		}
+		groups[k].hostnames = append(groups[k].hostnames, gatewayApi.Hostname(host))
+	}
+	named := map[string]*hostGroup[R]{}
+	for k, g := range groups {
+		if k == 0 {
+			named[name] = g
+		} else {
+			named[fmt.Sprintf("%s-%d", name, k)] = g
+		}
+	}
+	return named
+}
+
+func (h *Handler) buildRouteMeta(i *networking.Ingress, name string) meta.ObjectMeta {
+	ownersKey := viper.GetString(config.IngressOwnersAnnotation)
+	annotations := map[string]string{viper.GetString(config.RouteIngressAnnotation): i.Name}
+	if v, ok := i.Annotations[ownersKey]; ok {
+		annotations[ownersKey] = v
+	}
+	m := meta.ObjectMeta{
+		Name:            name,
+		Namespace:       i.Namespace,
+		Annotations:     annotations,
+		Labels:          maps.Clone(i.Labels),
+		OwnerReferences: slices.Clone(i.OwnerReferences),
+	}
+	setManagedKeys(&m)
+	return m
+}
+
+// buildRoutes converts an ingress into routes, splitting its backends by protocol into route kinds
+func (h *Handler) buildRoutes(i *networking.Ingress, routes routeSet) error {
+
+	hosts := make([]string, len(i.Spec.Rules))
+	httpRules := make([][]gatewayApi.HTTPRouteRule, len(i.Spec.Rules))
+	grpcRules := make([][]gatewayAlpha.GRPCRouteRule, len(i.Spec.Rules))
+	tlsRules := make([][]gatewayAlpha.TLSRouteRule, len(i.Spec.Rules))
+	for j, r := range i.Spec.Rules {
+		hosts[j] = r.Host
+		for _, p := range r.HTTP.Paths {
+			port, err := h.getBackendPort(i.Namespace, p.Backend.Service)
+			if err != nil {
+				return err
+			}
+			ref := buildBackendRef(p.Backend.Service.Name, port)
+			switch h.getBackendProtocol(i.Namespace, p.Backend.Service) {
+			case protocolGRPC:
+				grpcRules[j] = addGRPCRouteRule(grpcRules[j], ref, p)
+			case protocolTLS:
+				tlsRules[j] = addTLSRouteRule(tlsRules[j], ref)
+			default:
+				httpRules[j] = addHTTPRouteRule(httpRules[j], ref, p)
+			}
+		}
+	}
+
+	parentRefs := gatewayApi.CommonRouteSpec{ParentRefs: h.getParentRefs()}
+	for name, g := range groupHostRules(i.Name, hosts, httpRules) {
+		routes.http[cache.NewObjectName(i.Namespace, name).String()] = &gatewayApi.HTTPRoute{
+			ObjectMeta: h.buildRouteMeta(i, name),
+			Spec:       gatewayApi.HTTPRouteSpec{CommonRouteSpec: parentRefs, Hostnames: g.hostnames, Rules: g.rules},
+		}
+	}
+	for name, g := range groupHostRules(i.Name, hosts, grpcRules) {
+		routes.grpc[cache.NewObjectName(i.Namespace, name).String()] = &gatewayAlpha.GRPCRoute{
+			ObjectMeta: h.buildRouteMeta(i, name),
+			Spec:       gatewayAlpha.GRPCRouteSpec{CommonRouteSpec: parentRefs, Hostnames: g.hostnames, Rules: g.rules},
+		}
+	}
+	for name, g := range groupHostRules(i.Name, hosts, tlsRules) {
+		routes.tls[cache.NewObjectName(i.Namespace, name).String()] = &gatewayAlpha.TLSRoute{
+			ObjectMeta: h.buildRouteMeta(i, name),
+			Spec:       gatewayAlpha.TLSRouteSpec{CommonRouteSpec: parentRefs, Hostnames: g.hostnames, Rules: g.rules},
+		}
+	}
+	return nil
+
+}
+
+func (h *Handler) buildDesiredRoutes() (routeSet, error) {
+	var errs []error
+	routes := newRouteSet()
+	for k, i := range h.desiredIngresses {
+		if err := h.buildRoutes(i, routes); err != nil {
+			errs = append(errs, fmt.Errorf("error building routes for ingress %s: %v", k, err))
+		}
+	}
+	return routes, errors.Join(errs...)
+}
+
+func isOwnedRoute(r meta.Object) bool {
+	return isOwnedObject(r)
+}
+
+func compareRoutes[T routeObject](h *Handler, kind string, d T, c T) (specsAreEqual bool) {
+
+	if !h.compareMeta(kind, d, c) {
+		return
+	}
+
+	specsAreEqual = reflect.DeepEqual(routeSpec(d), routeSpec(c))
+	if !specsAreEqual {
+		h.logger.Debug(fmt.Sprintf("updated spec on %s %s", kind, c.GetName()))
+	}
+	return
+}
+
+func createRoute[T routeObject](h *Handler, kind string, client func(string) routeClient[T], r T) (T, error) {
+	h.logger.Info(fmt.Sprintf("creating %s %s", kind, r.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	r, err := client(r.GetNamespace()).Create(ctx, r, meta.CreateOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("create").Inc()
+		return r, fmt.Errorf("error creating %s: %v", kind, err)
+	}
+	metrics.RouteOperations.WithLabelValues("create").Inc()
+	h.recorder.Event(r, core.EventTypeNormal, "Created", "route created from labeled services")
+	return r, nil
+}
+
+func updateRoute[T routeObject](h *Handler, kind string, client func(string) routeClient[T], r T) (T, error) {
+	h.logger.Info(fmt.Sprintf("updating %s %s", kind, r.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	r, err := client(r.GetNamespace()).Update(ctx, r, meta.UpdateOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("update").Inc()
+		return r, fmt.Errorf("error updating %s: %v", kind, err)
+	}
+	metrics.RouteOperations.WithLabelValues("update").Inc()
+	h.recorder.Event(r, core.EventTypeNormal, "Updated", "route updated from labeled services")
+	return r, nil
+}
+
+func deleteRoute[T routeObject](h *Handler, kind string, client func(string) routeClient[T], r T) error {
+	h.logger.Info(fmt.Sprintf("deleting %s %s", kind, r.GetName()))
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	err := client(r.GetNamespace()).Delete(ctx, r.GetName(), meta.DeleteOptions{
+		DryRun: h.dryRun,
+	})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("delete").Inc()
+		return fmt.Errorf("error deleting %s %s/%s: %v", kind, r.GetNamespace(), r.GetName(), err)
+	}
+	metrics.RouteOperations.WithLabelValues("delete").Inc()
+	h.recorder.Event(r, core.EventTypeNormal, "Deleted", "route deleted, no labeled services left")
+	return nil
+}
+
+// reconcileRouteKind applies the desired routes of a kind. Current routes are kept as they are when
+// services were rejected, as they would lose the rejected services.
+func reconcileRouteKind[T routeObject](h *Handler, kind string, client func(string) routeClient[T], current map[string]T, desired map[string]T, rejected bool) error {
+
+	var errs []error
+
+	// Remove undesired routes, as long as the bot created them
+	for k, r := range current {
+		if _, ok := desired[k]; !ok {
+			if rejected {
+				h.logger.Debug(fmt.Sprintf("keeping %s %s with rejected services", kind, k))
+				continue
+			}
+			if !isOwnedRoute(r) {
+				h.logger.Debug(fmt.Sprintf("skipping deletion of unowned %s %s", kind, k))
+				continue
+			}
+			if err := deleteRoute(h, kind, client, r); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// Upsert desired routes
+	for k, r := range desired {
+		if c, ok := current[k]; ok {
+			if rejected {
+				h.logger.Debug(fmt.Sprintf("keeping %s %s with rejected services", kind, k))
+				continue
+			}
+			// Custom resources can't be updated without the current resource version
+			if !compareRoutes(h, kind, r, c) {
+				r.SetResourceVersion(c.GetResourceVersion())
+				u, err := updateRoute(h, kind, client, r)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				current[k] = u
+			}
+		} else {
+			u, err := createRoute(h, kind, client, r)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			current[k] = u
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Handler) reconcileRoutes(key string, rejected bool) error {
+
+	var err error
+	var errs []error
+
+	h.currentRoutes, err = h.fetchRoutes(key)
+	if err != nil {
+		return err
+	}
+	h.desiredRoutes, err = h.buildDesiredRoutes()
+	if err != nil {
+		errs = append(errs, err)
+		rejected = true
+	}
+
+	// Route kinds that aren't watched can't have desired routes, as their services are rejected
+	errs = append(errs,
+		reconcileRouteKind(h, "HTTPRoute", httpRouteClient, h.currentRoutes.http, h.desiredRoutes.http, rejected),
+		reconcileRouteKind(h, "GRPCRoute", grpcRouteClient, h.currentRoutes.grpc, h.desiredRoutes.grpc, rejected),
+		reconcileRouteKind(h, "TLSRoute", tlsRouteClient, h.currentRoutes.tls, h.desiredRoutes.tls, rejected),
+	)
+	return errors.Join(errs...)
+}
+
+func (h *Handler) enqueueRoute(obj interface{}) {
+	if t, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = t.Obj
+	}
+	if r, ok := obj.(meta.Object); ok {
+		h.queue.Add(h.routeIngressKey(r))
+	}
+}
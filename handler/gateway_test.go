@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sTesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	gatewayApi "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayFake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/typed/apis/v1/fake"
+	"testing"
+	"time"
+)
+
+var route = &gatewayApi.HTTPRoute{
+	ObjectMeta: meta.ObjectMeta{
+		Name:        "route",
+		Namespace:   "default",
+		Annotations: map[string]string{},
+		Labels:      map[string]string{},
+	},
+}
+
+func routeErrorReactor(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+	return true, &gatewayApi.HTTPRoute{}, errors.New("fake error")
+}
+
+func resetGatewayReactionChain() {
+	kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).ReactionChain = kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).ReactionChain[1:]
+}
+
+func Test_Gateway(t *testing.T) {
+
+	config.Load()
+	viper.Set(config.DryRun, "true")
+	viper.Set(config.OutputMode, config.OutputModeGateway)
+	viper.Set(config.GatewayName, "gateway")
+	viper.Set(config.GatewayNamespace, "gateway-system")
+	viper.Set(config.GatewayRouteKinds, "HTTPRoute,GRPCRoute,TLSRoute")
+	defer viper.Set(config.OutputMode, config.OutputModeIngress)
+	defer viper.Set(config.GatewayName, "")
+	defer viper.Set(config.GatewayNamespace, "")
+	defer viper.Set(config.GatewayRouteKinds, "HTTPRoute")
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+	route.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, _ := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	t.Run("fetch routes", func(t *testing.T) {
+		r2 := route.DeepCopy()
+		r2.Name = "www-example-com-1"
+		r2.Annotations[viper.GetString(config.RouteIngressAnnotation)] = "www-example-com"
+		r3 := route.DeepCopy()
+		r3.Name = "www-example-com"
+		h := newTestHandler(t, ctx, logger, route.DeepCopy(), r2, r3)
+		l, err := h.fetchRoutes("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l.http, 2)
+		assert.Len(t, l.grpc, 0)
+	})
+
+	t.Run("get backend port", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		s := service.DeepCopy()
+		s.Spec.Ports = []core.ServicePort{{Name: "web", Port: 8000}}
+		h.services = map[string]core.Service{"default/service": *s}
+		port, err := h.getBackendPort("default", &networking.IngressServiceBackend{Name: "service", Port: networking.ServiceBackendPort{Number: 8080}})
+		assert.NoError(t, err)
+		assert.Equal(t, gatewayApi.PortNumber(8080), port)
+		port, err = h.getBackendPort("default", &networking.IngressServiceBackend{Name: "service", Port: networking.ServiceBackendPort{Name: "web"}})
+		assert.NoError(t, err)
+		assert.Equal(t, gatewayApi.PortNumber(8000), port)
+		_, err = h.getBackendPort("default", &networking.IngressServiceBackend{Name: "service", Port: networking.ServiceBackendPort{Name: "grpc"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("get parent refs", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		refs := h.getParentRefs()
+		assert.Len(t, refs, 1)
+		assert.Equal(t, gatewayApi.ObjectName("gateway"), refs[0].Name)
+		assert.Equal(t, gatewayApi.Namespace("gateway-system"), *refs[0].Namespace)
+		assert.Nil(t, refs[0].SectionName)
+	})
+
+	t.Run("build grpc route matches", func(t *testing.T) {
+		pathType := networking.PathTypeImplementationSpecific
+		assert.Nil(t, buildGRPCRouteMatches(networking.HTTPIngressPath{Path: "", PathType: &pathType}))
+		m := buildGRPCRouteMatches(networking.HTTPIngressPath{Path: "/pkg.Service", PathType: &pathType})
+		assert.Equal(t, "pkg.Service", *m[0].Method.Service)
+		assert.Nil(t, m[0].Method.Method)
+	})
+
+	t.Run("build route match", func(t *testing.T) {
+		exact := networking.PathTypeExact
+		prefix := networking.PathTypeImplementationSpecific
+		m := buildRouteMatch(networking.HTTPIngressPath{Path: "/api", PathType: &exact})
+		assert.Equal(t, gatewayApi.PathMatchExact, *m.Path.Type)
+		assert.Equal(t, "/api", *m.Path.Value)
+		m = buildRouteMatch(networking.HTTPIngressPath{Path: "", PathType: &prefix})
+		assert.Equal(t, gatewayApi.PathMatchPathPrefix, *m.Path.Type)
+		assert.Equal(t, "/", *m.Path.Value)
+	})
+
+	t.Run("build routes", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2,/path3"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		h.desiredIngresses, _ = h.buildDesiredIngresses()
+		l, err := h.buildDesiredRoutes()
+		assert.NoError(t, err)
+		assert.Len(t, l.http, 1)
+		r := l.http["default/www-example-com"]
+		assert.Equal(t, []gatewayApi.Hostname{"www.example.com"}, r.Spec.Hostnames)
+		assert.Len(t, r.Spec.Rules, 2)
+		assert.Len(t, r.Spec.Rules[1].Matches, 2)
+		assert.Equal(t, "www-example-com", r.Annotations[viper.GetString(config.RouteIngressAnnotation)])
+		assert.Equal(t, "service,service2", r.Annotations[viper.GetString(config.IngressOwnersAnnotation)])
+		assert.Len(t, r.OwnerReferences, 2)
+	})
+	t.Run("build routes with host specific paths", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,www2.example.com,www3.example.com"
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/,www2.example.com/api"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		h.desiredIngresses, _ = h.buildDesiredIngresses()
+		l, err := h.buildDesiredRoutes()
+		assert.NoError(t, err)
+		assert.Len(t, l.http, 2)
+		assert.Equal(t, []gatewayApi.Hostname{"www.example.com", "www3.example.com"}, l.http["default/www-example-com"].Spec.Hostnames)
+		assert.Equal(t, []gatewayApi.Hostname{"www2.example.com"}, l.http["default/www-example-com-1"].Spec.Hostnames)
+		assert.Equal(t, "www-example-com", l.http["default/www-example-com-1"].Annotations[viper.GetString(config.RouteIngressAnnotation)])
+	})
+	t.Run("build routes by protocol", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "grpc"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/pkg.Service/Method"
+		s3 := service.DeepCopy()
+		s3.Name = "service3"
+		s3.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "tls"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, s3)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		h.desiredIngresses, _ = h.buildDesiredIngresses()
+		l, err := h.buildDesiredRoutes()
+		assert.NoError(t, err)
+		assert.Len(t, l.http["default/www-example-com"].Spec.Rules, 1)
+		assert.Len(t, l.grpc["default/www-example-com"].Spec.Rules, 1)
+		assert.Equal(t, "pkg.Service", *l.grpc["default/www-example-com"].Spec.Rules[0].Matches[0].Method.Service)
+		assert.Equal(t, "Method", *l.grpc["default/www-example-com"].Spec.Rules[0].Matches[0].Method.Method)
+		assert.Len(t, l.tls["default/www-example-com"].Spec.Rules, 1)
+		assert.Equal(t, gatewayApi.ObjectName("service3"), l.tls["default/www-example-com"].Spec.Rules[0].BackendRefs[0].Name)
+	})
+	t.Run("build routes with disabled route kind", func(t *testing.T) {
+		viper.Set(config.GatewayRouteKinds, "HTTPRoute")
+		defer viper.Set(config.GatewayRouteKinds, "HTTPRoute,GRPCRoute,TLSRoute")
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "grpc"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l, 0)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidProtocol")
+	})
+	t.Run("build routes with tls passthrough conflict", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "tls"
+		s2 := s.DeepCopy()
+		s2.Name = "service2"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		_, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring tls passthrough")
+	})
+	t.Run("build routes with unknown named port", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		i := ingress.DeepCopy()
+		p := httpIngressPath.DeepCopy()
+		p.Backend.Service.Port = networking.ServiceBackendPort{Name: "web"}
+		i.Spec.Rules[0].HTTP.Paths = []networking.HTTPIngressPath{*p}
+		h.desiredIngresses = map[string]*networking.Ingress{"default/ingress": i}
+		_, err := h.buildDesiredRoutes()
+		assert.Error(t, err)
+	})
+
+	t.Run("compare routes", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		cur := route.DeepCopy()
+		des := route.DeepCopy()
+		assert.True(t, compareRoutes(h, "HTTPRoute", des, cur))
+		des.Labels["new-label"] = "true"
+		assert.False(t, compareRoutes(h, "HTTPRoute", des, cur))
+		des = route.DeepCopy()
+		des.Spec.Hostnames = []gatewayApi.Hostname{"www.example.com"}
+		assert.False(t, compareRoutes(h, "HTTPRoute", des, cur))
+	})
+
+	t.Run("create route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		count := testutil.ToFloat64(metrics.RouteOperations.WithLabelValues("create"))
+		_, err := createRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy())
+		assert.NoError(t, err)
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.RouteOperations.WithLabelValues("create")))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Created")
+	})
+	t.Run("create route with error", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).PrependReactor("create", "httproutes", routeErrorReactor)
+		defer resetGatewayReactionChain()
+		_, err := createRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy())
+		assert.Error(t, err)
+	})
+	t.Run("update route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, route.DeepCopy())
+		_, err := updateRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy())
+		assert.NoError(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
+	})
+	t.Run("update route with error", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, route.DeepCopy())
+		kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).PrependReactor("update", "httproutes", routeErrorReactor)
+		defer resetGatewayReactionChain()
+		_, err := updateRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy())
+		assert.Error(t, err)
+	})
+	t.Run("delete route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, route.DeepCopy())
+		count := testutil.ToFloat64(metrics.RouteOperations.WithLabelValues("delete"))
+		assert.NoError(t, deleteRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy()))
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.RouteOperations.WithLabelValues("delete")))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Deleted")
+	})
+	t.Run("delete route with error", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, route.DeepCopy())
+		kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).PrependReactor("delete", "httproutes", routeErrorReactor)
+		defer resetGatewayReactionChain()
+		assert.Error(t, deleteRoute(h, "HTTPRoute", httpRouteClient, route.DeepCopy()))
+	})
+
+	t.Run("reconcile creating route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy())
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		r, err := kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []gatewayApi.Hostname{"www.example.com"}, r.Spec.Hostnames)
+		_, err = kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+	t.Run("reconcile updating route", func(t *testing.T) {
+		r := route.DeepCopy()
+		r.Name = "www-example-com"
+		r.ResourceVersion = "1"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), r)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		r, _ = kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Len(t, r.Spec.Rules, 1)
+	})
+	t.Run("reconcile creating grpc route", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "grpc"
+		h := newTestHandler(t, ctx, logger, s)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		_, err := kube.GatewayClientSet.GatewayV1alpha2().GRPCRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		_, err = kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+	t.Run("reconcile deleting route", func(t *testing.T) {
+		r := route.DeepCopy()
+		r.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), r)
+		assert.NoError(t, h.reconcile("default/route"))
+		_, err := kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "route", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+	t.Run("reconcile keeping route of rejected service", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressPortAnnotation)] = "nope"
+		r := route.DeepCopy()
+		r.Name = "www-example-com"
+		r.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, s, r)
+		assert.Error(t, h.reconcile("default/www-example-com"))
+		_, err := kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+	t.Run("reconcile skipping unowned route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), route.DeepCopy())
+		assert.NoError(t, h.reconcile("default/route"))
+		_, err := kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "route", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+	t.Run("reconcile with error creating route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy())
+		kube.GatewayClientSet.GatewayV1().(*gatewayFake.FakeGatewayV1).PrependReactor("create", "httproutes", routeErrorReactor)
+		defer resetGatewayReactionChain()
+		assert.Error(t, h.reconcile("default/www-example-com"))
+	})
+
+	t.Run("enqueue route", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		r := route.DeepCopy()
+		r.Annotations[viper.GetString(config.RouteIngressAnnotation)] = "www-example-com"
+		h.enqueueRoute(r)
+		h.enqueueRoute(cache.DeletedFinalStateUnknown{Obj: r})
+		assert.Equal(t, 1, h.queue.Len())
+		key, _ := h.queue.Get()
+		assert.Equal(t, "default/www-example-com", key)
+	})
+	t.Run("resync", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), route.DeepCopy())
+		for h.queue.Len() > 0 {
+			key, _ := h.queue.Get()
+			h.queue.Done(key)
+		}
+		h.resync()
+		assert.Equal(t, 2, h.queue.Len())
+	})
+
+	t.Run("run", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		go h.Run()
+		_, _ = kube.ClientSet.CoreV1().Services("default").Create(ctx, service.DeepCopy(), meta.CreateOptions{})
+		assert.Eventually(t, func() bool {
+			_, err := kube.GatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "www-example-com", meta.GetOptions{})
+			return err == nil
+		}, 5*time.Second, 100*time.Millisecond)
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(metrics.ManagedRoutes) == 1
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+
+}
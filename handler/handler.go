@@ -2,62 +2,185 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/health"
 	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"io"
 	core "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	coreInformers "k8s.io/client-go/informers/core/v1"
+	networkingInformers "k8s.io/client-go/informers/networking/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedCore "k8s.io/client-go/kubernetes/typed/core/v1"
+	coreListers "k8s.io/client-go/listers/core/v1"
+	networkingListers "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"maps"
 	"reflect"
+	gatewayApi "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayAlpha "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gatewayScheme "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/scheme"
+	gatewayInformers "sigs.k8s.io/gateway-api/pkg/client/informers/externalversions"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Service indexes, keyed by ingress key and by host
+const (
+	ingressIndex = "ingress"
+	hostIndex    = "host"
+)
+
 type Handler struct {
-	ctx              context.Context
-	logger           *zap.Logger
-	listOpt          meta.ListOptions
-	services         map[string]core.Service
-	currentIngresses map[string]*networking.Ingress
-	desiredIngresses map[string]*networking.Ingress
-	dryRun           []string
+	ctx               context.Context
+	logger            *zap.Logger
+	timeout           time.Duration
+	outputMode        string
+	serviceSelector   labels.Selector
+	ingressSelector   labels.Selector
+	namespaceSelector labels.Selector
+	namespaces        []string
+	informers         []informers.SharedInformerFactory
+	gatewayInformers  []gatewayInformers.SharedInformerFactory
+	dynamicInformers  []dynamicinformer.DynamicSharedInformerFactory
+	namespaceLister   coreListers.NamespaceLister
+	serviceLister     *namespacedLister[*core.Service]
+	ingressLister     *namespacedLister[*networking.Ingress]
+	httpRouteLister   lister[*gatewayApi.HTTPRoute]
+	grpcRouteLister   lister[*gatewayAlpha.GRPCRoute]
+	tlsRouteLister    lister[*gatewayAlpha.TLSRoute]
+	certificateLister lister[runtime.Object]
+	routeKinds        []string
+	queue             workqueue.RateLimitingInterface
+	broadcaster       record.EventBroadcaster
+	recorder          record.EventRecorder
+	services          map[string]core.Service
+	currentIngresses  map[string]*networking.Ingress
+	desiredIngresses  map[string]*networking.Ingress
+	currentRoutes     routeSet
+	desiredRoutes     routeSet
+	failing           map[string]bool
+	dryRun            []string
 }
 
-func (h *Handler) fetchServices() (map[string]core.Service, error) {
-	l, err := kube.ClientSet.CoreV1().Services("").List(h.ctx, h.listOpt)
+func (h *Handler) fetchServices(key string) (map[string]core.Service, error) {
+	l, err := h.serviceLister.ByIndex(ingressIndex, key)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching services: %v", err)
 	}
 	list := map[string]core.Service{}
-	for _, v := range l.Items {
-		list[v.Name] = v
+	for _, v := range l {
+		list[cache.NewObjectName(v.Namespace, v.Name).String()] = *v
 	}
 	return list, nil
 }
 
-func (h *Handler) fetchIngresses() (map[string]*networking.Ingress, error) {
-	l, err := kube.ClientSet.NetworkingV1().Ingresses("").List(h.ctx, h.listOpt)
+func (h *Handler) fetchIngresses(key string) (map[string]*networking.Ingress, error) {
+	i, ok, err := h.ingressLister.GetByKey(key)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching ingresses: %v", err)
 	}
 	list := map[string]*networking.Ingress{}
-	for _, v := range l.Items {
-		list[v.Name] = &v
+	if ok {
+		list[key] = i
 	}
 	return list, nil
 }
 
-func (h *Handler) getServiceAnnotations(s *core.Service) ([]string, string, string) {
+// getServiceAnnotations returns the hosts, class and ingress name of the service. Hosts may have a
+// leading wildcard label, and are validated so invalid hosts never reach the ingress.
+func (h *Handler) getServiceAnnotations(s *core.Service) ([]string, string, string, error) {
+	var errs []error
 	hosts := strings.Split(s.Annotations[viper.GetString(config.IngressHostAnnotation)], ",")
+	for k, host := range hosts {
+		hosts[k] = strings.TrimSpace(host)
+		var invalid []string
+		switch {
+		case hosts[k] == "":
+			invalid = []string{"empty host"}
+		case strings.HasPrefix(hosts[k], "*."):
+			invalid = validation.IsWildcardDNS1123Subdomain(hosts[k])
+		default:
+			invalid = validation.IsDNS1123Subdomain(hosts[k])
+		}
+		if len(invalid) > 0 {
+			errs = append(errs, fmt.Errorf("service %s/%s has invalid host %s: %s", s.Namespace, s.Name, hosts[k], strings.Join(invalid, ", ")))
+		}
+	}
 	class := s.Annotations[viper.GetString(config.IngressClassAnnotation)]
-	name := strings.Replace(hosts[0], ".", "-", -1)
-	return hosts, class, name
+	name := h.getIngressName(s, hosts[0])
+	return hosts, class, name, errors.Join(errs...)
+}
+
+// getServiceTLS reads the tls annotation, falling back to the global setting
+func (h *Handler) getServiceTLS(s *core.Service) (bool, error) {
+	v := s.Annotations[viper.GetString(config.IngressTLSAnnotation)]
+	if v == "" {
+		return viper.GetBool(config.IngressEnableTLS), nil
+	}
+	enableTLS, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("service %s/%s has invalid tls setting %s", s.Namespace, s.Name, v)
+	}
+	return enableTLS, nil
+}
+
+func (h *Handler) serviceIngressKey(s *core.Service) string {
+	_, _, name, _ := h.getServiceAnnotations(s)
+	return cache.NewObjectName(s.Namespace, name).String()
+}
+
+// serviceIndexers index services by ingress key and by host, so the services of an ingress, or
+// sharing a host, are looked up without rendering the ingress name of every service
+func (h *Handler) serviceIndexers() cache.Indexers {
+	return cache.Indexers{
+		ingressIndex: func(obj interface{}) ([]string, error) {
+			if s, ok := obj.(*core.Service); ok {
+				return []string{h.serviceIngressKey(s)}, nil
+			}
+			return nil, nil
+		},
+		hostIndex: func(obj interface{}) ([]string, error) {
+			if s, ok := obj.(*core.Service); ok {
+				hosts, _, _, _ := h.getServiceAnnotations(s)
+				return hosts, nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+func buildIngressRule(host string) networking.IngressRule {
+	return networking.IngressRule{
+		Host: host,
+		IngressRuleValue: networking.IngressRuleValue{
+			HTTP: &networking.HTTPIngressRuleValue{
+				Paths: []networking.HTTPIngressPath{},
+			},
+		},
+	}
 }
 
-func (h *Handler) buildIngress(name string, namespace string, hosts []string, class string) *networking.Ingress {
+func (h *Handler) buildIngress(name string, namespace string, hosts []string, class string, enableTLS bool) *networking.Ingress {
 
 	var rules []networking.IngressRule
 	var tls []networking.IngressTLS
@@ -65,14 +188,7 @@ func (h *Handler) buildIngress(name string, namespace string, hosts []string, cl
 
 	// Set ingress rules
 	for _, v := range hosts {
-		rules = append(rules, networking.IngressRule{
-			Host: v,
-			IngressRuleValue: networking.IngressRuleValue{
-				HTTP: &networking.HTTPIngressRuleValue{
-					Paths: []networking.HTTPIngressPath{},
-				},
-			},
-		})
+		rules = append(rules, buildIngressRule(v))
 	}
 
 	// Set annotations
@@ -82,21 +198,11 @@ func (h *Handler) buildIngress(name string, namespace string, hosts []string, cl
 	}
 
 	// Set labels
-	labels := map[string]string{
-		viper.GetString(config.ResourceLabelKey): viper.GetString(config.ResourceLabelValue),
-	}
-	if viper.IsSet(config.IngressLabels) {
-		maps.Copy(labels, viper.GetStringMapString(config.IngressLabels))
-	}
+	labels := managedLabels()
 
 	// Set TLS
-	if viper.GetBool(config.IngressEnableTLS) {
-		tls = []networking.IngressTLS{
-			{
-				Hosts:      hosts,
-				SecretName: fmt.Sprintf("%s-tls", name),
-			},
-		}
+	if enableTLS {
+		tls = h.buildIngressTLS(name, namespace, hosts, nil)
 	}
 
 	if class == "" {
@@ -122,77 +228,447 @@ func (h *Handler) buildIngress(name string, namespace string, hosts []string, cl
 
 }
 
-func (h *Handler) attachServiceToIngress(i *networking.Ingress, s core.Service) {
+func (h *Handler) getServicePort(s *core.Service) (networking.ServiceBackendPort, error) {
 
-	for _, rule := range i.Spec.Rules {
-		for j, p := range rule.HTTP.Paths {
-			if p.Backend.Service.Name == s.Name {
-				rule.HTTP.Paths = append(rule.HTTP.Paths[:j], rule.HTTP.Paths[j+1:]...)
-				break
+	// Select the annotated port by number or name, referencing named ports by name
+	if v := s.Annotations[viper.GetString(config.IngressPortAnnotation)]; v != "" {
+		for _, p := range s.Spec.Ports {
+			if strconv.Itoa(int(p.Port)) == v {
+				return networking.ServiceBackendPort{Number: p.Port}, nil
+			}
+			if p.Name == v {
+				return networking.ServiceBackendPort{Name: p.Name}, nil
+			}
+		}
+		return networking.ServiceBackendPort{}, fmt.Errorf("service %s/%s has no port %s", s.Namespace, s.Name, v)
+	}
+
+	// Default to the port named http, then to the first port
+	if len(s.Spec.Ports) == 0 {
+		return networking.ServiceBackendPort{}, fmt.Errorf("service %s/%s has no ports", s.Namespace, s.Name)
+	}
+	for _, p := range s.Spec.Ports {
+		if p.Name == "http" {
+			return networking.ServiceBackendPort{Number: p.Port}, nil
+		}
+	}
+	return networking.ServiceBackendPort{Number: s.Spec.Ports[0].Port}, nil
+
+}
+
+// getServiceProtocol reads the protocol annotation, accepting non http protocols only
+// when the matching gateway route kind is enabled
+func (h *Handler) getServiceProtocol(s *core.Service) (string, error) {
+	protocol := s.Annotations[viper.GetString(config.IngressProtocolAnnotation)]
+	if protocol == "" {
+		protocol = protocolHTTP
+	}
+	kind, ok := protocolRouteKinds[protocol]
+	if !ok {
+		return "", fmt.Errorf("service %s/%s has unknown protocol %s", s.Namespace, s.Name, protocol)
+	}
+	if h.outputMode == config.OutputModeGateway && !slices.Contains(h.routeKinds, kind) {
+		return "", fmt.Errorf("service %s/%s has protocol %s, but %s output is disabled", s.Namespace, s.Name, protocol, kind)
+	}
+	if h.outputMode != config.OutputModeGateway && protocol != protocolHTTP {
+		return "", fmt.Errorf("service %s/%s has protocol %s, which requires gateway output", s.Namespace, s.Name, protocol)
+	}
+	return protocol, nil
+}
+
+func validatePath(p networking.HTTPIngressPath) error {
+	switch *p.PathType {
+	case networking.PathTypeExact, networking.PathTypePrefix:
+		if !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("path %q must be absolute", p.Path)
+		}
+		for _, seq := range []string{"//", "/./", "/../", "%2f", "%2F"} {
+			if strings.Contains(p.Path, seq) {
+				return fmt.Errorf("path %q must not contain %q", p.Path, seq)
 			}
 		}
-		pathType := networking.PathType(viper.GetString(config.IngressPathType))
-		rule.HTTP.Paths = append(rule.HTTP.Paths, networking.HTTPIngressPath{
-			Path:     s.Annotations[viper.GetString(config.IngressPathAnnotation)],
-			PathType: &pathType,
-			Backend: networking.IngressBackend{
-				Service: &networking.IngressServiceBackend{
-					Name: s.Name,
-					Port: networking.ServiceBackendPort{
-						Number: s.Spec.Ports[0].Port,
+		for _, suffix := range []string{"/..", "/."} {
+			if strings.HasSuffix(p.Path, suffix) {
+				return fmt.Errorf("path %q must not end with %q", p.Path, suffix)
+			}
+		}
+	case networking.PathTypeImplementationSpecific:
+		if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("path %q must be absolute", p.Path)
+		}
+	default:
+		return fmt.Errorf("path %q has unsupported type %q", p.Path, *p.PathType)
+	}
+	return nil
+}
+
+func (h *Handler) parseServicePath(v string) (string, networking.HTTPIngressPath, error) {
+
+	// Split the optional path type suffix
+	v = strings.TrimSpace(v)
+	pathType := networking.PathType(viper.GetString(config.IngressPathType))
+	if j := strings.LastIndex(v, ":"); j >= 0 {
+		switch t := networking.PathType(v[j+1:]); t {
+		case networking.PathTypeExact, networking.PathTypePrefix, networking.PathTypeImplementationSpecific:
+			v, pathType = v[:j], t
+		}
+	}
+
+	// Split the optional host prefix
+	host := ""
+	if j := strings.Index(v, "/"); j > 0 {
+		host, v = v[:j], v[j:]
+	}
+
+	p := networking.HTTPIngressPath{Path: v, PathType: &pathType}
+	return host, p, validatePath(p)
+
+}
+
+// defaultServicePath serves the whole host, with an empty path only where the path type allows it
+func defaultServicePath() (networking.HTTPIngressPath, error) {
+	pathType := networking.PathType(viper.GetString(config.IngressPathType))
+	p := networking.HTTPIngressPath{Path: "/", PathType: &pathType}
+	if pathType == networking.PathTypeImplementationSpecific {
+		p.Path = ""
+	}
+	return p, validatePath(p)
+}
+
+// getServicePaths parses a comma separated list of paths, each optionally
+// prefixed with a host and suffixed with ":<path type>". Paths are returned
+// by host, with unqualified paths under "" applying to every other host.
+func (h *Handler) getServicePaths(s *core.Service) (map[string][]networking.HTTPIngressPath, error) {
+	hosts, _, _, _ := h.getServiceAnnotations(s)
+	paths := map[string][]networking.HTTPIngressPath{}
+	var entries []string
+	if v := s.Annotations[viper.GetString(config.IngressPathAnnotation)]; v != "" {
+		entries = strings.Split(v, ",")
+	}
+	for _, v := range entries {
+		host, p, err := h.parseServicePath(v)
+		if err != nil {
+			return nil, fmt.Errorf("service %s/%s has invalid path: %v", s.Namespace, s.Name, err)
+		}
+		if host != "" && !slices.Contains(hosts, host) {
+			return nil, fmt.Errorf("service %s/%s has path %s for undeclared host %s", s.Namespace, s.Name, p.Path, host)
+		}
+		paths[host] = append(paths[host], p)
+	}
+
+	// Hosts without paths of their own get the default path
+	missing := slices.ContainsFunc(hosts, func(host string) bool { _, ok := paths[host]; return !ok })
+	if _, ok := paths[""]; !ok && missing {
+		p, err := defaultServicePath()
+		if err != nil {
+			return nil, fmt.Errorf("service %s/%s has invalid default path: %v", s.Namespace, s.Name, err)
+		}
+		paths[""] = []networking.HTTPIngressPath{p}
+	}
+	return paths, nil
+}
+
+func (h *Handler) attachServiceToIngress(i *networking.Ingress, s core.Service, paths map[string][]networking.HTTPIngressPath, port networking.ServiceBackendPort) {
+
+	// Services grouped on an ingress may declare hosts the ingress doesn't serve yet
+	hosts, _, _, _ := h.getServiceAnnotations(&s)
+	for _, host := range hosts {
+		if !slices.ContainsFunc(i.Spec.Rules, func(r networking.IngressRule) bool { return r.Host == host }) {
+			i.Spec.Rules = append(i.Spec.Rules, buildIngressRule(host))
+		}
+	}
+
+	for _, rule := range i.Spec.Rules {
+		rule.HTTP.Paths = slices.DeleteFunc(rule.HTTP.Paths, func(p networking.HTTPIngressPath) bool {
+			return p.Backend.Service.Name == s.Name
+		})
+		if !slices.Contains(hosts, rule.Host) {
+			continue
+		}
+		hostPaths, ok := paths[rule.Host]
+		if !ok {
+			hostPaths = paths[""]
+		}
+		for _, p := range hostPaths {
+			rule.HTTP.Paths = append(rule.HTTP.Paths, networking.HTTPIngressPath{
+				Path:     p.Path,
+				PathType: p.PathType,
+				Backend: networking.IngressBackend{
+					Service: &networking.IngressServiceBackend{
+						Name: s.Name,
+						Port: port,
 					},
 				},
-			},
-		})
+			})
+		}
+	}
+
+}
+
+func servicePrecedes(a *core.Service, b *core.Service) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+func (h *Handler) sortedServices() []core.Service {
+	var services []core.Service
+	for _, s := range h.services {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return servicePrecedes(&services[i], &services[j])
+	})
+	return services
+}
+
+// conflictingEntry returns a key declared with different values by two services
+func conflictingEntry(a map[string]string, b map[string]string) (string, string) {
+	for k, v := range b {
+		if o, ok := a[k]; ok && o != v {
+			return k, v
+		}
+	}
+	return "", ""
+}
+
+// hostClaimant returns the oldest service from another namespace claiming one of the hosts
+func (h *Handler) hostClaimant(s *core.Service, hosts []string) (*core.Service, string) {
+	var claimant *core.Service
+	var claimed string
+	for _, host := range hosts {
+		services, _ := h.serviceLister.ByIndex(hostIndex, host)
+		for _, o := range services {
+			if o.Namespace == s.Namespace || !servicePrecedes(o, s) {
+				continue
+			}
+			if claimant == nil || servicePrecedes(o, claimant) {
+				claimant, claimed = o, host
+			}
+		}
+	}
+	return claimant, claimed
+}
+
+func (h *Handler) addIngressOwner(i *networking.Ingress, s core.Service) {
+
+	// Reference the service so the ingress is garbage collected with its last owner
+	for _, o := range i.OwnerReferences {
+		if o.Kind == "Service" && o.Name == s.Name {
+			return
+		}
+	}
+	i.OwnerReferences = append(i.OwnerReferences, meta.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       s.Name,
+		UID:        s.UID,
+	})
+
+	// List contributing services on the ownership annotation
+	key := viper.GetString(config.IngressOwnersAnnotation)
+	var owners []string
+	if i.Annotations[key] != "" {
+		owners = strings.Split(i.Annotations[key], ",")
 	}
+	owners = append(owners, s.Name)
+	sort.Strings(owners)
+	i.Annotations[key] = strings.Join(owners, ",")
 
 }
 
+func (h *Handler) isOwnedIngress(i *networking.Ingress) bool {
+	return isOwnedObject(i)
+}
+
+// recordOwnersEvent records an event on every service owning the ingress
+func (h *Handler) recordOwnersEvent(i *networking.Ingress, eventType string, reason string, message string) {
+	for _, o := range i.OwnerReferences {
+		if s, ok := h.services[cache.NewObjectName(i.Namespace, o.Name).String()]; ok {
+			h.recorder.Event(&s, eventType, reason, message)
+		}
+	}
+}
+
+func (h *Handler) rejectService(s *core.Service, reason string, err error) error {
+	h.recorder.Event(s, core.EventTypeWarning, reason, err.Error())
+	return err
+}
+
 func (h *Handler) buildDesiredIngresses() (ingresses map[string]*networking.Ingress, err error) {
 
+	// Services are processed oldest first, so the earliest claim on an ingress or host wins any conflict
+	var errs []error
 	ingresses = map[string]*networking.Ingress{}
-	for _, s := range h.services {
-		hosts, class, name := h.getServiceAnnotations(&s)
-		if _, ok := ingresses[name]; ok {
-			if ingresses[name].Namespace != s.Namespace {
-				return nil, fmt.Errorf("service %s/%s declaring host for ingress %s/%s",
-					s.Namespace, s.Name, ingresses[name].Namespace, ingresses[name].Name)
+	passthrough := map[string]string{}
+	tlsSecrets := map[string]map[string]string{}
+	ingressAnnotations := map[string]map[string]string{}
+	ingressLabels := map[string]map[string]string{}
+	for _, s := range h.sortedServices() {
+		hosts, class, name, err := h.getServiceAnnotations(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidHost", err))
+			continue
+		}
+		key := h.serviceIngressKey(&s)
+		port, err := h.getServicePort(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidPort", err))
+			continue
+		}
+		protocol, err := h.getServiceProtocol(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidProtocol", err))
+			continue
+		}
+		enableTLS, err := h.getServiceTLS(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidTLS", err))
+			continue
+		}
+		issuer, err := h.getServiceIssuer(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidIssuer", err))
+			continue
+		}
+		secrets, err := h.getServiceTLSSecrets(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidTLSSecret", err))
+			continue
+		}
+		paths, err := h.getServicePaths(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidPath", err))
+			continue
+		}
+		annotations, labels, err := h.getServiceIngressMeta(&s)
+		if err != nil {
+			errs = append(errs, h.rejectService(&s, "InvalidPassthrough", err))
+			continue
+		}
+		if o, host := h.hostClaimant(&s, hosts); o != nil {
+			errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring host %s claimed by service %s/%s",
+				s.Namespace, s.Name, host, o.Namespace, o.Name)))
+			continue
+		}
+		if o, ok := passthrough[key]; ok && protocol == protocolTLS {
+			errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring tls passthrough for ingress %s served by service %s",
+				s.Namespace, s.Name, key, o)))
+			continue
+		}
+		ingressHosts, ingressSecrets := slices.Clone(hosts), maps.Clone(secrets)
+		if i, ok := ingresses[key]; ok {
+			for _, rule := range i.Spec.Rules {
+				ingressHosts = append(ingressHosts, rule.Host)
 			}
-			if ingresses[name].Spec.IngressClassName != nil && *ingresses[name].Spec.IngressClassName != class {
-				return nil, fmt.Errorf("service %s/%s declaring class %s for ingress %s/%s",
-					s.Namespace, s.Name, class, ingresses[name].Namespace, ingresses[name].Name)
+			maps.Copy(ingressSecrets, tlsSecrets[key])
+		}
+		if host := unissuedTLSHost(ingressHosts, ingressSecrets, enableTLS, issuer); host != "" {
+			errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring tls secrets for ingress %s, leaving host %s without certificate",
+				s.Namespace, s.Name, key, host)))
+			continue
+		}
+		if _, ok := ingresses[key]; ok {
+			if ingresses[key].Spec.IngressClassName != nil && *ingresses[key].Spec.IngressClassName != class {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring class %s for ingress %s",
+					s.Namespace, s.Name, class, key)))
+				continue
+			}
+			if (len(ingresses[key].Spec.TLS) > 0) != enableTLS {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring tls %t for ingress %s",
+					s.Namespace, s.Name, enableTLS, key)))
+				continue
+			}
+			if len(ingresses[key].Spec.TLS) > 0 && ingresses[key].Annotations[viper.GetString(config.IngressIssuerAnnotation)] != issuer {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring issuer %s for ingress %s",
+					s.Namespace, s.Name, issuer, key)))
+				continue
+			}
+			if host, secret := conflictingEntry(tlsSecrets[key], secrets); host != "" {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring tls secret %s for host %s of ingress %s",
+					s.Namespace, s.Name, secret, host, key)))
+				continue
+			}
+			if k, v := conflictingEntry(ingressAnnotations[key], annotations); k != "" {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring annotation %s=%s for ingress %s",
+					s.Namespace, s.Name, k, v, key)))
+				continue
+			}
+			if k, v := conflictingEntry(ingressLabels[key], labels); k != "" {
+				errs = append(errs, h.rejectService(&s, "IngressConflict", fmt.Errorf("service %s/%s declaring label %s=%s for ingress %s",
+					s.Namespace, s.Name, k, v, key)))
+				continue
 			}
 		} else {
-			h.logger.Debug(fmt.Sprintf("adding ingress %s/%s to desired list", s.Namespace, name))
-			ingresses[name] = h.buildIngress(name, s.Namespace, hosts, class)
+			h.logger.Debug(fmt.Sprintf("adding ingress %s to desired list", key))
+			ingresses[key] = h.buildIngress(name, s.Namespace, hosts, class, enableTLS)
+			h.setIngressIssuer(ingresses[key], issuer)
 		}
-		h.logger.Debug(fmt.Sprintf("adding service %s to ingress %s/%s", s.Name, s.Namespace, name))
-		h.attachServiceToIngress(ingresses[name], s)
+		h.logger.Debug(fmt.Sprintf("adding service %s to ingress %s", s.Name, key))
+		h.attachServiceToIngress(ingresses[key], s, paths, port)
+		h.addIngressOwner(ingresses[key], s)
+		if protocol == protocolTLS {
+			passthrough[key] = s.Name
+		}
+		if tlsSecrets[key] == nil {
+			tlsSecrets[key], ingressAnnotations[key], ingressLabels[key] = map[string]string{}, map[string]string{}, map[string]string{}
+		}
+		maps.Copy(tlsSecrets[key], secrets)
+		maps.Copy(ingressAnnotations[key], annotations)
+		maps.Copy(ingressLabels[key], labels)
+		h.setIngressMeta(ingresses[key], annotations, labels)
 	}
-	return
+
+	// Tls entries are regrouped once every service has been attached, covering hosts added by
+	// grouped services and existing secrets
+	for key, i := range ingresses {
+		h.setIngressTLSSecrets(i, tlsSecrets[key])
+		setManagedKeys(i)
+	}
+	return ingresses, errors.Join(errs...)
 }
 
-func (h *Handler) compareIngresses(d *networking.Ingress, c *networking.Ingress) (specsAreEqual bool) {
+// compareMeta ignores keys added by others, while keys the bot stops setting change the managed
+// keys annotation, so they are removed by the update
+func (h *Handler) compareMeta(kind string, d meta.Object, c meta.Object) bool {
 
-	if d.Namespace != c.Namespace {
-		h.logger.Debug(fmt.Sprintf("new namespace on ingress %s", c.Name))
-		return
+	if d.GetNamespace() != c.GetNamespace() {
+		h.logger.Debug(fmt.Sprintf("new namespace on %s %s", kind, c.GetName()))
+		return false
 	}
 
-	for k, v := range d.Annotations {
-		if v != c.Annotations[k] {
-			h.logger.Debug(fmt.Sprintf("updated annotations on ingress %s", c.Name))
-			return
+	for k, v := range d.GetAnnotations() {
+		if v != c.GetAnnotations()[k] {
+			h.logger.Debug(fmt.Sprintf("updated annotations on %s %s", kind, c.GetName()))
+			return false
 		}
 	}
 
-	for k, v := range d.Labels {
-		if v != c.Labels[k] {
-			h.logger.Debug(fmt.Sprintf("updated labels on ingress %s", c.Name))
-			return
+	for k, v := range d.GetLabels() {
+		if v != c.GetLabels()[k] {
+			h.logger.Debug(fmt.Sprintf("updated labels on %s %s", kind, c.GetName()))
+			return false
 		}
 	}
 
+	if !reflect.DeepEqual(d.GetOwnerReferences(), c.GetOwnerReferences()) {
+		h.logger.Debug(fmt.Sprintf("updated owners on %s %s", kind, c.GetName()))
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) compareIngresses(d *networking.Ingress, c *networking.Ingress) (specsAreEqual bool) {
+
+	if !h.compareMeta("ingress", d, c) {
+		return
+	}
+
 	specsAreEqual = reflect.DeepEqual(d.Spec, c.Spec)
 	if !specsAreEqual {
 		h.logger.Debug(fmt.Sprintf("updated spec on ingress %s", c.Name))
@@ -200,119 +676,581 @@ func (h *Handler) compareIngresses(d *networking.Ingress, c *networking.Ingress)
 	return
 }
 
+// requestContext bounds api requests, so a hung request can't stall the worker
+func (h *Handler) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(h.ctx, h.timeout)
+}
+
 func (h *Handler) createIngress(i *networking.Ingress) (*networking.Ingress, error) {
 	h.logger.Info(fmt.Sprintf("creating ingress %s", i.Name))
-	i, err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Create(h.ctx, i, meta.CreateOptions{
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	i, err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Create(ctx, i, meta.CreateOptions{
 		DryRun: h.dryRun,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating ingress: %v", err)
+		metrics.APIErrors.WithLabelValues("create").Inc()
+		return nil, fmt.Errorf("error creating ingress: %w", err)
+	}
+	metrics.IngressOperations.WithLabelValues("create").Inc()
+	h.recorder.Event(i, core.EventTypeNormal, "Created", "ingress created from labeled services")
+	return i, nil
+}
+
+// adoptionRefusal returns why an existing ingress can't be adopted under the adoption policy, or
+// empty when it can. Ingresses created by other instances are never adopted, and the instance's
+// own ingresses are expected to be handled before.
+func adoptionRefusal(i *networking.Ingress) string {
+	if i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] != "" {
+		return "is managed by another instance"
+	}
+	switch viper.GetString(config.IngressAdoptionPolicy) {
+	case config.AdoptionPolicyForce:
+		return ""
+	case config.AdoptionPolicyAnnotation:
+		if v, _ := strconv.ParseBool(i.Annotations[viper.GetString(config.IngressAdoptAnnotation)]); v {
+			return ""
+		}
+		return fmt.Sprintf("is missing the %s annotation", viper.GetString(config.IngressAdoptAnnotation))
+	default:
+		return "adoption is disabled"
+	}
+}
+
+// ingressRefusal returns why the bot can't update or delete an ingress of the lister, or empty when it
+// can. Ingresses matching the selector without being owned, such as those created before ownership
+// was recorded, go through the adoption policy.
+func (h *Handler) ingressRefusal(i *networking.Ingress) string {
+	if h.isOwnedIngress(i) {
+		return ""
+	}
+	return adoptionRefusal(i)
+}
+
+// adoptIngress takes over an existing ingress the bot doesn't watch by updating it with the desired
+// ingress. Refusals are reported on the services instead of failing, since retrying can't help.
+func (h *Handler) adoptIngress(d *networking.Ingress) (*networking.Ingress, error) {
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	c, err := kube.ClientSet.NetworkingV1().Ingresses(d.Namespace).Get(ctx, d.Name, meta.GetOptions{})
+	if err != nil {
+		metrics.APIErrors.WithLabelValues("get").Inc()
+		return nil, fmt.Errorf("error fetching existing ingress: %v", err)
+	}
+	// The instance's own ingress is missing from the lister when it lags behind a create, or when
+	// the ingress left the selector, so it's simply updated
+	if isOwnedObject(c) {
+		d.ResourceVersion = c.ResourceVersion
+		return h.updateIngress(d)
+	}
+	if reason := adoptionRefusal(c); reason != "" {
+		msg := fmt.Sprintf("ingress %s/%s already exists and %s", c.Namespace, c.Name, reason)
+		h.logger.Info(msg)
+		h.recordOwnersEvent(d, core.EventTypeWarning, "IngressConflict", msg)
+		return nil, nil
+	}
+	h.logger.Info(fmt.Sprintf("adopting ingress %s", c.Name))
+	d.ResourceVersion = c.ResourceVersion
+	i, err := h.updateIngress(d)
+	if err != nil {
+		return nil, err
 	}
+	h.recorder.Event(i, core.EventTypeNormal, "Adopted", "existing ingress adopted by labeled services")
 	return i, nil
 }
 
 func (h *Handler) updateIngress(i *networking.Ingress) (*networking.Ingress, error) {
 	h.logger.Info(fmt.Sprintf("updating ingress %s", i.Name))
-	i, err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Update(h.ctx, i, meta.UpdateOptions{
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	i, err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Update(ctx, i, meta.UpdateOptions{
 		DryRun: h.dryRun,
 	})
 	if err != nil {
+		metrics.APIErrors.WithLabelValues("update").Inc()
 		return nil, fmt.Errorf("error updating ingress: %v", err)
 	}
+	metrics.IngressOperations.WithLabelValues("update").Inc()
+	h.recorder.Event(i, core.EventTypeNormal, "Updated", "ingress updated from labeled services")
 	return i, nil
 }
 
 func (h *Handler) deleteIngress(i *networking.Ingress) error {
 	h.logger.Info(fmt.Sprintf("deleting ingress %s", i.Name))
-	err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Delete(h.ctx, i.Name, meta.DeleteOptions{
+	ctx, cancel := h.requestContext()
+	defer cancel()
+	err := kube.ClientSet.NetworkingV1().Ingresses(i.Namespace).Delete(ctx, i.Name, meta.DeleteOptions{
 		DryRun: h.dryRun,
 	})
 	if err != nil {
+		metrics.APIErrors.WithLabelValues("delete").Inc()
 		return fmt.Errorf("error deleting ingress %s/%s: %v", i.Namespace, i.Name, err)
 	}
+	metrics.IngressOperations.WithLabelValues("delete").Inc()
+	h.recorder.Event(i, core.EventTypeNormal, "Deleted", "ingress deleted, no labeled services left")
 	return nil
 }
 
-func (h *Handler) reconcile() error {
+func (h *Handler) reconcile(key string) error {
 
 	var err error
+	var errs []error
 	var i *networking.Ingress
 
-	h.services, err = h.fetchServices()
-	if err != nil {
-		return err
-	}
-	h.currentIngresses, err = h.fetchIngresses()
+	h.services, err = h.fetchServices(key)
 	if err != nil {
 		return err
 	}
+
+	// Conflicting services are left out of the desired ingresses and reported after the rest is applied.
+	// Existing outputs would lose the rejected services, so they are kept as they are until every
+	// service is valid, while missing outputs are still created.
 	h.desiredIngresses, err = h.buildDesiredIngresses()
+	rejected := err != nil
+	if rejected {
+		errs = append(errs, err)
+	}
+
+	// In gateway mode the desired ingresses are only a blueprint for the routes
+	if h.outputMode == config.OutputModeGateway {
+		return errors.Join(append(errs, h.reconcileRoutes(key, rejected))...)
+	}
+
+	h.currentIngresses, err = h.fetchIngresses(key)
 	if err != nil {
-		return err
+		return errors.Join(append(errs, err)...)
 	}
 
 	// Remove undesired ingresses
-	for _, ingress := range h.currentIngresses {
-		// Remove serviceless ingresses
-		if _, ok := h.desiredIngresses[ingress.Name]; !ok {
+	for k, ingress := range h.currentIngresses {
+		// Remove serviceless ingresses, as long as the bot created them
+		if _, ok := h.desiredIngresses[k]; !ok {
+			if rejected {
+				h.logger.Debug(fmt.Sprintf("keeping ingress %s with rejected services", k))
+				continue
+			}
+			if reason := h.ingressRefusal(ingress); reason != "" {
+				h.logger.Debug(fmt.Sprintf("skipping deletion of unowned ingress %s: %s", k, reason))
+				continue
+			}
 			err = h.deleteIngress(ingress)
 			if err != nil {
-				return err
+				errs = append(errs, err)
 			}
 		}
 	}
 
 	// Upsert desired ingresses
-	for _, ingress := range h.desiredIngresses {
-		if _, ok := h.currentIngresses[ingress.Name]; ok {
+	for k, ingress := range h.desiredIngresses {
+		if _, ok := h.currentIngresses[k]; ok {
 			// Update existing ingress
-			if !h.compareIngresses(h.desiredIngresses[ingress.Name], h.currentIngresses[ingress.Name]) {
+			if rejected {
+				h.logger.Debug(fmt.Sprintf("keeping ingress %s with rejected services", k))
+				continue
+			}
+			if !h.compareIngresses(ingress, h.currentIngresses[k]) {
+				c := h.currentIngresses[k]
+				if reason := h.ingressRefusal(c); reason != "" {
+					msg := fmt.Sprintf("ingress %s/%s is not owned and %s", c.Namespace, c.Name, reason)
+					h.logger.Info(msg)
+					h.recordOwnersEvent(ingress, core.EventTypeWarning, "IngressConflict", msg)
+					continue
+				}
+				adopted := !h.isOwnedIngress(c)
 				i, err = h.updateIngress(ingress)
-				h.currentIngresses[ingress.Name] = i
 				if err != nil {
-					return err
+					errs = append(errs, err)
+					continue
+				}
+				if adopted {
+					h.recorder.Event(i, core.EventTypeNormal, "Adopted", "existing ingress adopted by labeled services")
 				}
+				h.currentIngresses[k] = i
 			}
 		} else {
-			// Create new ingress
+			// Create new ingress, or adopt an existing one out of the selector
 			i, err = h.createIngress(ingress)
-			h.currentIngresses[ingress.Name] = i
+			if apiErrors.IsAlreadyExists(err) {
+				i, err = h.adoptIngress(ingress)
+			}
 			if err != nil {
-				return err
+				errs = append(errs, err)
+				continue
+			}
+			if i != nil {
+				h.currentIngresses[k] = i
 			}
 		}
 	}
-	return err
+
+	if h.certificateLister != nil {
+		if err = h.reconcileCertificates(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (h *Handler) ReconciliationLoop() {
-	for {
-		err := h.reconcile()
-		if err != nil {
-			h.logger.Error(err.Error())
-			break
+func (h *Handler) enqueueService(obj interface{}) {
+	if t, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = t.Obj
+	}
+	s, ok := obj.(*core.Service)
+	if !ok {
+		return
+	}
+	key := h.serviceIngressKey(s)
+	h.queue.Add(key)
+
+	// Services in other namespaces sharing a host may be affected by this claim
+	hosts, _, _, _ := h.getServiceAnnotations(s)
+	for _, host := range hosts {
+		services, _ := h.serviceLister.ByIndex(hostIndex, host)
+		for _, o := range services {
+			if o.Namespace != s.Namespace {
+				h.queue.Add(h.serviceIngressKey(o))
+			}
+		}
+	}
+}
+
+func (h *Handler) enqueueIngress(obj interface{}) {
+	if t, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = t.Obj
+	}
+	if i, ok := obj.(*networking.Ingress); ok {
+		h.queue.Add(cache.NewObjectName(i.Namespace, i.Name).String())
+	}
+}
+
+func (h *Handler) resync() {
+	h.logger.Debug("resyncing all ingresses")
+	services, _ := h.serviceLister.List(labels.Everything())
+	for _, s := range services {
+		h.enqueueService(s)
+	}
+	if h.ingressLister != nil {
+		ingresses, _ := h.ingressLister.List(labels.Everything())
+		for _, i := range ingresses {
+			h.enqueueIngress(i)
+		}
+	}
+	for _, r := range h.listRoutes() {
+		h.enqueueRoute(r)
+	}
+}
+
+// updateManagedGauges counts the watched objects, which lists every informer, so it only runs once
+// the queue drains instead of after every item
+func (h *Handler) updateManagedGauges() {
+	services, _ := h.serviceLister.List(labels.Everything())
+	metrics.ManagedServices.Set(float64(len(services)))
+	if h.ingressLister != nil {
+		ingresses, _ := h.ingressLister.List(labels.Everything())
+		metrics.ManagedIngresses.Set(float64(len(ingresses)))
+	}
+	if h.outputMode == config.OutputModeGateway {
+		metrics.ManagedRoutes.Set(float64(len(h.listRoutes())))
+	}
+}
+
+// processNextItem reconciles the next queued ingress. Liveness is only reported from here, where
+// waiting on an empty queue counts as alive, so a worker stuck on an item fails the probe.
+func (h *Handler) processNextItem() bool {
+	if h.queue.Len() == 0 {
+		h.updateManagedGauges()
+		health.Idle(len(h.failing) == 0)
+	}
+	key, shutdown := h.queue.Get()
+	if shutdown {
+		return false
+	}
+	health.Busy()
+	defer h.queue.Done(key)
+	start := time.Now()
+	err := h.reconcile(key.(string))
+	if err != nil {
+		metrics.ReconcileDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		h.logger.Error(fmt.Sprintf("error reconciling ingress %s (retry %d): %v", key, h.queue.NumRequeues(key), err))
+		h.failing[key.(string)] = true
+		h.queue.AddRateLimited(key)
+		return true
+	}
+	metrics.ReconcileDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	metrics.LastSuccessfulReconcile.SetToCurrentTime()
+	health.Reconciled()
+	delete(h.failing, key.(string))
+	h.queue.Forget(key)
+	return true
+}
+
+func (h *Handler) worker() {
+	for h.processNextItem() {
+	}
+}
+
+func (h *Handler) startInformers(stopCh <-chan struct{}) {
+	for _, f := range h.informers {
+		f.Start(stopCh)
+	}
+	for _, f := range h.gatewayInformers {
+		f.Start(stopCh)
+	}
+	for _, f := range h.dynamicInformers {
+		f.Start(stopCh)
+	}
+}
+
+// waitForCacheSync returns the sync result of every informer, prefixed by its namespace in namespaced mode
+func (h *Handler) waitForCacheSync(stopCh <-chan struct{}) map[string]bool {
+	synced := map[string]bool{}
+	name := func(k int, t fmt.Stringer) string {
+		if h.namespaces[k] == meta.NamespaceAll {
+			return t.String()
+		}
+		return fmt.Sprintf("%s/%v", h.namespaces[k], t)
+	}
+	for k, f := range h.informers {
+		for t, ok := range f.WaitForCacheSync(stopCh) {
+			synced[name(k, t)] = ok
+		}
+	}
+	for k, f := range h.gatewayInformers {
+		for t, ok := range f.WaitForCacheSync(stopCh) {
+			synced[name(k, t)] = ok
+		}
+	}
+	for k, f := range h.dynamicInformers {
+		for r, ok := range f.WaitForCacheSync(stopCh) {
+			synced[name(k, r)] = ok
 		}
-		time.Sleep(viper.GetDuration(config.CheckInterval) * time.Second)
-		if viper.GetDuration(config.CheckInterval) == 0 {
-			break
+	}
+	return synced
+}
+
+func (h *Handler) shutdownInformers() {
+	for _, f := range h.informers {
+		f.Shutdown()
+	}
+	for _, f := range h.gatewayInformers {
+		f.Shutdown()
+	}
+	for _, f := range h.dynamicInformers {
+		f.Shutdown()
+	}
+}
+
+func (h *Handler) Run() {
+	defer h.queue.ShutDown()
+	defer h.broadcaster.Shutdown()
+	health.Start()
+	h.broadcaster.StartRecordingToSink(&typedCore.EventSinkImpl{Interface: kube.ClientSet.CoreV1().Events("")})
+
+	// Start informers and wait for the initial listing
+	h.startInformers(h.ctx.Done())
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+	synced := h.waitForCacheSync(ctx.Done())
+	for t, ok := range synced {
+		if !ok {
+			h.logger.Error(fmt.Sprintf("error syncing %v cache", t))
+			return
 		}
 	}
+
+	// Process queued ingresses and periodically requeue everything as a safety net
+	go wait.Until(h.worker, time.Second, h.ctx.Done())
+	if interval := viper.GetDuration(config.CheckInterval) * time.Second; interval > 0 {
+		go wait.Until(h.resync, interval, h.ctx.Done())
+	}
+	<-h.ctx.Done()
+}
+
+// ValidateConfig checks settings that would otherwise only fail during reconciliation
+func ValidateConfig() error {
+	if viper.GetString(config.OutputMode) == config.OutputModeGateway && viper.GetString(config.GatewayName) == "" {
+		return errors.New("gateway output mode requires a gateway name")
+	}
+	if err := validateSelectors(); err != nil {
+		return err
+	}
+	if policy := viper.GetString(config.IngressAdoptionPolicy); !slices.Contains([]string{config.AdoptionPolicyRefuse, config.AdoptionPolicyAnnotation, config.AdoptionPolicyForce}, policy) {
+		return fmt.Errorf("invalid %s: %s", config.IngressAdoptionPolicy, policy)
+	}
+	if _, err := namespaceSelector(); err != nil {
+		return err
+	}
+	if len(watchedNamespaces()) == 0 {
+		return fmt.Errorf("every namespace in %s is excluded", config.WatchNamespaces)
+	}
+	for _, setting := range []string{config.IngressAnnotationAllowlist, config.IngressAnnotationDenylist} {
+		if _, err := parseAnnotationPolicy(setting); err != nil {
+			return err
+		}
+	}
+	n, err := parseIngressNameTemplate()
+	if err == nil {
+		err = n.Execute(io.Discard, ingressNameData{Namespace: "default", Service: "service", Host: "www.example.com"})
+	}
+	if err != nil {
+		return fmt.Errorf("invalid ingress name template: %v", err)
+	}
+	for _, setting := range []string{config.IngressTLSSecretTemplate, config.IngressWildcardTLSTemplate} {
+		t, err := parseTLSSecretTemplate(setting)
+		if err == nil {
+			err = t.Execute(io.Discard, tlsSecretData{Namespace: "default", Name: "ingress", Host: "*.example.com", Domain: "example.com"})
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", setting, err)
+		}
+	}
+	return nil
 }
 
 func Factory(ctx context.Context, logger *zap.Logger, timeout int64) *Handler {
+	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(
+		viper.GetDuration(config.RetryBaseDelay)*time.Second,
+		viper.GetDuration(config.RetryMaxDelay)*time.Second,
+	)
 	h := &Handler{
 		ctx:              ctx,
 		logger:           logger,
+		timeout:          time.Duration(timeout) * time.Second,
+		queue:            workqueue.NewRateLimitingQueue(rateLimiter),
+		outputMode:       viper.GetString(config.OutputMode),
 		services:         map[string]core.Service{},
 		currentIngresses: map[string]*networking.Ingress{},
 		desiredIngresses: map[string]*networking.Ingress{},
-		listOpt: meta.ListOptions{
-			TimeoutSeconds: &timeout,
-			LabelSelector:  viper.GetString(config.ResourceLabelKey),
-		},
+		currentRoutes:    newRouteSet(),
+		desiredRoutes:    newRouteSet(),
+		failing:          map[string]bool{},
 	}
 	if viper.GetBool(config.DryRun) {
 		h.dryRun = []string{"All"}
 	}
+
+	// Record events on services, ingresses and routes
+	eventScheme := runtime.NewScheme()
+	_ = scheme.AddToScheme(eventScheme)
+	_ = gatewayScheme.AddToScheme(eventScheme)
+	h.broadcaster = record.NewBroadcaster()
+	h.recorder = h.broadcaster.NewRecorder(eventScheme, core.EventSource{Component: "ingress-bot"})
+
+	// Watch selected services, and either selected ingresses or selected routes
+	var err error
+	if h.serviceSelector, err = resourceSelector(config.ServiceSelector); err != nil {
+		logger.Error(err.Error())
+		h.serviceSelector = defaultSelector()
+	}
+	if h.ingressSelector, err = outputSelector(); err != nil {
+		logger.Error(err.Error())
+		h.ingressSelector = defaultSelector()
+	}
+	serviceTweak := func(o *meta.ListOptions) { o.LabelSelector = h.serviceSelector.String() }
+	tweak := func(o *meta.ListOptions) { o.LabelSelector = h.ingressSelector.String() }
+	for _, kind := range strings.Split(viper.GetString(config.GatewayRouteKinds), ",") {
+		h.routeKinds = append(h.routeKinds, strings.TrimSpace(kind))
+	}
+	serviceHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.enqueueService,
+		UpdateFunc: func(o, n interface{}) { h.enqueueService(o); h.enqueueService(n) },
+		DeleteFunc: h.enqueueService,
+	}
+	ingressHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.enqueueIngress,
+		UpdateFunc: func(o, n interface{}) { h.enqueueIngress(n) },
+		DeleteFunc: h.enqueueIngress,
+	}
+	routeHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.enqueueRoute,
+		UpdateFunc: func(o, n interface{}) { h.enqueueRoute(n) },
+		DeleteFunc: h.enqueueRoute,
+	}
+	certificateHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.enqueueCertificate,
+		UpdateFunc: func(o, n interface{}) { h.enqueueCertificate(n) },
+		DeleteFunc: h.enqueueCertificate,
+	}
+
+	// Informers run on each watched namespace, and listers merge them back together
+	services := &namespacedLister[*core.Service]{watches: h.watchesService}
+	ingresses := &namespacedLister[*networking.Ingress]{watches: h.watchesObject}
+	httpRoutes := &namespacedLister[*gatewayApi.HTTPRoute]{watches: h.watchesObject}
+	grpcRoutes := &namespacedLister[*gatewayAlpha.GRPCRoute]{watches: h.watchesObject}
+	tlsRoutes := &namespacedLister[*gatewayAlpha.TLSRoute]{watches: h.watchesObject}
+	certificates := &namespacedLister[runtime.Object]{watches: h.watchesObject}
+	h.namespaces = watchedNamespaces()
+	for _, ns := range h.namespaces {
+		f := informers.NewSharedInformerFactoryWithOptions(kube.ClientSet, 0, informers.WithNamespace(ns), informers.WithTweakListOptions(serviceTweak))
+		h.informers = append(h.informers, f)
+		services.listers = append(services.listers, f.Core().V1().Services().Lister())
+		_ = f.Core().V1().Services().Informer().AddIndexers(h.serviceIndexers())
+		services.indexers = append(services.indexers, f.Core().V1().Services().Informer().GetIndexer())
+		_, _ = f.Core().V1().Services().Informer().AddEventHandler(serviceHandler)
+		if h.outputMode == config.OutputModeGateway {
+			g := gatewayInformers.NewSharedInformerFactoryWithOptions(kube.GatewayClientSet, 0, gatewayInformers.WithNamespace(ns), gatewayInformers.WithTweakListOptions(tweak))
+			h.gatewayInformers = append(h.gatewayInformers, g)
+			// Only enabled route kinds are watched, as the experimental ones may not be installed
+			if slices.Contains(h.routeKinds, protocolRouteKinds[protocolHTTP]) {
+				httpRoutes.listers = append(httpRoutes.listers, g.Gateway().V1().HTTPRoutes().Lister())
+				_, _ = g.Gateway().V1().HTTPRoutes().Informer().AddEventHandler(routeHandler)
+			}
+			if slices.Contains(h.routeKinds, protocolRouteKinds[protocolGRPC]) {
+				grpcRoutes.listers = append(grpcRoutes.listers, g.Gateway().V1alpha2().GRPCRoutes().Lister())
+				_, _ = g.Gateway().V1alpha2().GRPCRoutes().Informer().AddEventHandler(routeHandler)
+			}
+			if slices.Contains(h.routeKinds, protocolRouteKinds[protocolTLS]) {
+				tlsRoutes.listers = append(tlsRoutes.listers, g.Gateway().V1alpha2().TLSRoutes().Lister())
+				_, _ = g.Gateway().V1alpha2().TLSRoutes().Informer().AddEventHandler(routeHandler)
+			}
+			continue
+		}
+		// Ingresses have a selector of their own, so their informer is filtered apart from the factory
+		i := f.InformerFor(&networking.Ingress{}, func(c kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			return networkingInformers.NewFilteredIngressInformer(c, ns, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, tweak)
+		})
+		ingresses.listers = append(ingresses.listers, networkingListers.NewIngressLister(i.GetIndexer()))
+		ingresses.indexers = append(ingresses.indexers, i.GetIndexer())
+		_, _ = i.AddEventHandler(ingressHandler)
+		// Watch selected certificates when the bot manages them, as cert-manager may not be installed otherwise
+		if viper.GetBool(config.CertManagerCertificates) {
+			d := dynamicinformer.NewFilteredDynamicSharedInformerFactory(kube.DynamicClient, 0, ns, tweak)
+			h.dynamicInformers = append(h.dynamicInformers, d)
+			certificates.listers = append(certificates.listers, d.ForResource(kube.CertificateResource).Lister())
+			_, _ = d.ForResource(kube.CertificateResource).Informer().AddEventHandler(certificateHandler)
+		}
+	}
+	h.serviceLister = services
+	if h.outputMode == config.OutputModeGateway {
+		if len(httpRoutes.listers) > 0 {
+			h.httpRouteLister = httpRoutes
+		}
+		if len(grpcRoutes.listers) > 0 {
+			h.grpcRouteLister = grpcRoutes
+		}
+		if len(tlsRoutes.listers) > 0 {
+			h.tlsRouteLister = tlsRoutes
+		}
+	} else {
+		h.ingressLister = ingresses
+		if len(certificates.listers) > 0 {
+			h.certificateLister = certificates
+		}
+	}
+
+	// Watch selected namespaces, which requires reading namespaces even in namespaced mode
+	if viper.GetString(config.NamespaceSelector) != "" && len(h.informers) > 0 {
+		if h.namespaceSelector, err = namespaceSelector(); err != nil {
+			logger.Error(err.Error())
+			h.namespaceSelector = labels.Nothing()
+		}
+		n := h.informers[0].InformerFor(&core.Namespace{}, func(c kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			return coreInformers.NewFilteredNamespaceInformer(c, resync, cache.Indexers{}, func(o *meta.ListOptions) { o.LabelSelector = h.namespaceSelector.String() })
+		})
+		h.namespaceLister = coreListers.NewNamespaceLister(n.GetIndexer())
+		// Namespaces entering the selector are added to the filtered informer
+		_, _ = n.AddEventHandler(cache.ResourceEventHandlerFuncs{AddFunc: h.enqueueNamespace})
+	}
+
 	return h
 }
@@ -3,8 +3,10 @@ package handler
 import (
 	"context"
 	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/ptonini/ingress-bot/config"
 	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -17,6 +19,8 @@ import (
 	coreFake "k8s.io/client-go/kubernetes/typed/core/v1/fake"
 	networkingFake "k8s.io/client-go/kubernetes/typed/networking/v1/fake"
 	k8sTesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"testing"
 	"time"
 )
@@ -70,22 +74,28 @@ func serviceListErrorReactor(action k8sTesting.Action) (handled bool, ret runtim
 	return true, &core.ServiceList{}, errors.New("fake error")
 }
 
-func ingressListErrorReactor(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
-	return true, &networking.IngressList{}, errors.New("fake error")
-}
-
 func ingressErrorReactor(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
 	return true, &networking.Ingress{}, errors.New("fake error")
 }
 
-func resetCoreReactionChain() {
-	kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).ReactionChain = kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).ReactionChain[1:]
-}
-
 func resetNetworkingReactionChain() {
 	kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).ReactionChain = kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).ReactionChain[1:]
 }
 
+func newTestHandler(t *testing.T, ctx context.Context, logger *zap.Logger, objects ...runtime.Object) *Handler {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), objects))
+	_ = kube.GetClientSet(ctx, logger)
+	h := Factory(ctx, logger, viper.GetInt64(config.ClientTimeout))
+	h.recorder = record.NewFakeRecorder(100)
+	t.Cleanup(func() {
+		cancel()
+		h.shutdownInformers()
+	})
+	h.startInformers(ctx.Done())
+	h.waitForCacheSync(ctx.Done())
+	return h
+}
+
 func Test_Handler(t *testing.T) {
 
 	config.Load()
@@ -103,50 +113,77 @@ func Test_Handler(t *testing.T) {
 	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
 	logger := zap.New(observedZapCore)
 
-	h := Factory(ctx, logger, viper.GetInt64(config.ClientTimeout))
+	h := newTestHandler(t, ctx, logger)
 
 	t.Run("fetch services", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service})
-		_ = kube.GetClientSet(ctx, logger)
-		l, err := h.fetchServices()
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www2.example.com"
+		s3 := service.DeepCopy()
+		s3.Namespace = "alternative"
+		h := newTestHandler(t, ctx, logger, service, s2, s3)
+		l, err := h.fetchServices("default/www-example-com")
 		assert.NoError(t, err)
 		assert.Len(t, l, 1)
+		assert.Contains(t, l, "default/service")
 	})
-	t.Run("fetch services with error", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service})
-		_ = kube.GetClientSet(ctx, logger)
-		kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).PrependReactor("list", "services", serviceListErrorReactor)
-		defer resetCoreReactionChain()
-		_, err := h.fetchServices()
-		assert.Error(t, err)
+	t.Run("look services up by host", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www2.example.com, www.example.com"
+		s3 := service.DeepCopy()
+		s3.Name = "service3"
+		s3.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www3.example.com"
+		h := newTestHandler(t, ctx, logger, service, s2, s3)
+		l, err := h.serviceLister.ByIndex(hostIndex, "www.example.com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 2)
+		l, err = h.serviceLister.ByIndex(ingressIndex, "default/www2-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "service2", l[0].Name)
 	})
 
 	t.Run("fetch ingresses", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
-		l, err := h.fetchIngresses()
+		i2 := ingress.DeepCopy()
+		i2.Name = "ingress2"
+		i3 := ingress.DeepCopy()
+		i3.Namespace = "alternative"
+		h := newTestHandler(t, ctx, logger, ingress, i2, i3)
+		l, err := h.fetchIngresses("default/ingress")
 		assert.NoError(t, err)
 		assert.Len(t, l, 1)
-	})
-	t.Run("fetch ingresses with error", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
-		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("list", "ingresses", ingressListErrorReactor)
-		defer resetNetworkingReactionChain()
-		_, err := h.fetchIngresses()
-		assert.Error(t, err)
+		assert.Contains(t, l, "default/ingress")
 	})
 
 	t.Run("get service annotations", func(t *testing.T) {
-		host, class, name := h.getServiceAnnotations(service)
+		host, class, name, err := h.getServiceAnnotations(service)
+		assert.NoError(t, err)
 		assert.NotEmpty(t, host)
 		assert.NotEmpty(t, class)
 		assert.NotEmpty(t, name)
 	})
 
+	t.Run("get service annotations with wildcard host", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "*.apps.example.com, apps.example.com"
+		hosts, _, name, err := h.getServiceAnnotations(s)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"*.apps.example.com", "apps.example.com"}, hosts)
+		assert.Equal(t, "wildcard-apps-example-com", name)
+	})
+	t.Run("get service annotations with invalid hosts", func(t *testing.T) {
+		for _, v := range []string{"*.*.example.com", "www.*.example.com", "*example.com", "www_example.com", "www.example.com,", ""} {
+			s := service.DeepCopy()
+			s.Annotations[viper.GetString(config.IngressHostAnnotation)] = v
+			_, _, _, err := h.getServiceAnnotations(s)
+			assert.Error(t, err, v)
+		}
+	})
+
 	t.Run("build ingress", func(t *testing.T) {
 		className := "default"
-		i := h.buildIngress("test", "default", []string{"www.example.com", "example.com"}, className)
+		i := h.buildIngress("test", "default", []string{"www.example.com", "example.com"}, className, true)
 		assert.Len(t, i.Annotations, 1)
 		assert.Len(t, i.Labels, 2)
 		assert.Len(t, i.Spec.TLS, 1)
@@ -154,16 +191,153 @@ func Test_Handler(t *testing.T) {
 		assert.Equal(t, &className, i.Spec.IngressClassName)
 	})
 	t.Run("build classless ingress", func(t *testing.T) {
-		i := h.buildIngress("test", "default", []string{"www.example.com"}, "")
+		i := h.buildIngress("test", "default", []string{"www.example.com"}, "", true)
 		assert.Nil(t, i.Spec.IngressClassName)
 	})
 
+	t.Run("get service protocol", func(t *testing.T) {
+		s := service.DeepCopy()
+		protocol, err := h.getServiceProtocol(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "http", protocol)
+		s.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "udp"
+		_, err = h.getServiceProtocol(s)
+		assert.Error(t, err)
+		s.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "tls"
+		_, err = h.getServiceProtocol(s)
+		assert.Error(t, err)
+	})
+
+	t.Run("get service port", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Spec.Ports = []core.ServicePort{{Name: "grpc", Port: 9000}, {Name: "http", Port: 8080}, {Name: "metrics", Port: 9090}}
+		port, err := h.getServicePort(s)
+		assert.NoError(t, err)
+		assert.Equal(t, networking.ServiceBackendPort{Number: 8080}, port)
+		s.Annotations[viper.GetString(config.IngressPortAnnotation)] = "9090"
+		port, err = h.getServicePort(s)
+		assert.NoError(t, err)
+		assert.Equal(t, networking.ServiceBackendPort{Number: 9090}, port)
+		s.Annotations[viper.GetString(config.IngressPortAnnotation)] = "grpc"
+		port, err = h.getServicePort(s)
+		assert.NoError(t, err)
+		assert.Equal(t, networking.ServiceBackendPort{Name: "grpc"}, port)
+	})
+	t.Run("get default service port", func(t *testing.T) {
+		port, err := h.getServicePort(service.DeepCopy())
+		assert.NoError(t, err)
+		assert.Equal(t, networking.ServiceBackendPort{Number: 8080}, port)
+	})
+	t.Run("get service port with error", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressPortAnnotation)] = "admin"
+		_, err := h.getServicePort(s)
+		assert.Error(t, err)
+		s = service.DeepCopy()
+		s.Spec.Ports = nil
+		_, err = h.getServicePort(s)
+		assert.Error(t, err)
+	})
+
+	t.Run("get service paths", func(t *testing.T) {
+		s := service.DeepCopy()
+		paths, err := h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Len(t, paths[""], 1)
+		assert.Equal(t, "", paths[""][0].Path)
+		assert.Equal(t, networking.PathTypeImplementationSpecific, *paths[""][0].PathType)
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/api:Prefix, /v2/api:Exact,/legacy,/a:b:ImplementationSpecific"
+		paths, err = h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Len(t, paths, 1)
+		assert.Len(t, paths[""], 4)
+		assert.Equal(t, "/api", paths[""][0].Path)
+		assert.Equal(t, networking.PathTypePrefix, *paths[""][0].PathType)
+		assert.Equal(t, "/v2/api", paths[""][1].Path)
+		assert.Equal(t, networking.PathTypeExact, *paths[""][1].PathType)
+		assert.Equal(t, "/legacy", paths[""][2].Path)
+		assert.Equal(t, networking.PathTypeImplementationSpecific, *paths[""][2].PathType)
+		assert.Equal(t, "/a:b", paths[""][3].Path)
+	})
+	t.Run("get service paths by host", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "app.example.com,shared.example.com"
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "app.example.com/:Prefix,shared.example.com/app:Prefix,shared.example.com/app2"
+		paths, err := h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Len(t, paths["app.example.com"], 1)
+		assert.Equal(t, "/", paths["app.example.com"][0].Path)
+		assert.Len(t, paths["shared.example.com"], 2)
+		assert.Equal(t, "/app", paths["shared.example.com"][0].Path)
+		assert.Equal(t, "/app2", paths["shared.example.com"][1].Path)
+		assert.NotContains(t, paths, "")
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "app.example.com/:Prefix"
+		paths, err = h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Len(t, paths[""], 1)
+		assert.Equal(t, "", paths[""][0].Path)
+	})
+	t.Run("get service paths with global path type", func(t *testing.T) {
+		viper.Set(config.IngressPathType, "Prefix")
+		defer viper.Set(config.IngressPathType, "ImplementationSpecific")
+		s := service.DeepCopy()
+		paths, err := h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "/", paths[""][0].Path)
+		assert.Equal(t, networking.PathTypePrefix, *paths[""][0].PathType)
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "a.example.com,b.example.com"
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "a.example.com/:Prefix,b.example.com/app:Exact"
+		paths, err = h.getServicePaths(s)
+		assert.NoError(t, err)
+		assert.Len(t, paths, 2)
+		assert.Equal(t, networking.PathTypeExact, *paths["b.example.com"][0].PathType)
+	})
+	t.Run("get service paths with error", func(t *testing.T) {
+		for _, v := range []string{"api:Prefix", ":Exact", "/a//b:Prefix", "/a/..:Exact", "/a/%2f:Prefix", "relative", "other.example.com/app"} {
+			s := service.DeepCopy()
+			s.Annotations[viper.GetString(config.IngressPathAnnotation)] = v
+			_, err := h.getServicePaths(s)
+			assert.Error(t, err, v)
+		}
+		viper.Set(config.IngressPathType, "Regex")
+		defer viper.Set(config.IngressPathType, "ImplementationSpecific")
+		_, err := h.getServicePaths(service.DeepCopy())
+		assert.Error(t, err)
+	})
+
 	t.Run("attach service to ingress", func(t *testing.T) {
 		s := service.DeepCopy()
 		i := ingress.DeepCopy()
-		h.attachServiceToIngress(i, *s)
+		port, _ := h.getServicePort(s)
+		paths, _ := h.getServicePaths(s)
+		h.attachServiceToIngress(i, *s, paths, port)
 		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 1)
 	})
+	t.Run("attach service with multiple paths to ingress", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/api:Prefix,/v2/api:Exact"
+		i := ingress.DeepCopy()
+		p := httpIngressPath.DeepCopy()
+		p.Path = "/path"
+		i.Spec.Rules[0].HTTP.Paths = append(i.Spec.Rules[0].HTTP.Paths, *p, *p)
+		port, _ := h.getServicePort(s)
+		paths, _ := h.getServicePaths(s)
+		h.attachServiceToIngress(i, *s, paths, port)
+		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 2)
+		assert.Equal(t, "/api", i.Spec.Rules[0].HTTP.Paths[0].Path)
+		assert.Equal(t, "/v2/api", i.Spec.Rules[0].HTTP.Paths[1].Path)
+	})
+	t.Run("attach service with host paths to ingress", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,shared.example.com"
+		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/,shared.example.com/app"
+		i := h.buildIngress("test", "default", []string{"www.example.com", "shared.example.com"}, "", true)
+		port, _ := h.getServicePort(s)
+		paths, _ := h.getServicePaths(s)
+		h.attachServiceToIngress(i, *s, paths, port)
+		assert.Equal(t, "/", i.Spec.Rules[0].HTTP.Paths[0].Path)
+		assert.Equal(t, "/app", i.Spec.Rules[1].HTTP.Paths[0].Path)
+	})
 	t.Run("reattach service to ingress", func(t *testing.T) {
 		s := service.DeepCopy()
 		s.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/new_path"
@@ -172,12 +346,27 @@ func Test_Handler(t *testing.T) {
 		p := httpIngressPath.DeepCopy()
 		p.Path = "/path"
 		i.Spec.Rules[0].HTTP.Paths = append(i.Spec.Rules[0].HTTP.Paths, *p)
-		h.attachServiceToIngress(i, *s)
+		port, _ := h.getServicePort(s)
+		paths, _ := h.getServicePaths(s)
+		h.attachServiceToIngress(i, *s, paths, port)
 		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 1)
 		assert.Equal(t, s.Spec.Ports[0].Port, i.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number)
 		assert.Equal(t, s.Annotations[viper.GetString(config.IngressPathAnnotation)], i.Spec.Rules[0].HTTP.Paths[0].Path)
 	})
 
+	t.Run("add ingress owner", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		i := ingress.DeepCopy()
+		h.addIngressOwner(i, *s2)
+		h.addIngressOwner(i, *service.DeepCopy())
+		h.addIngressOwner(i, *s2)
+		assert.Len(t, i.OwnerReferences, 2)
+		assert.Equal(t, "service,service2", i.Annotations[viper.GetString(config.IngressOwnersAnnotation)])
+		assert.True(t, h.isOwnedIngress(i))
+		assert.False(t, h.isOwnedIngress(ingress))
+	})
+
 	t.Run("compare ingresses", func(t *testing.T) {
 		cur := ingress.DeepCopy()
 		des := ingress.DeepCopy()
@@ -201,6 +390,12 @@ func Test_Handler(t *testing.T) {
 		des.Labels["new-label"] = "true"
 		assert.False(t, h.compareIngresses(des, cur))
 	})
+	t.Run("compare ingresses with new owner", func(t *testing.T) {
+		cur := ingress.DeepCopy()
+		des := ingress.DeepCopy()
+		des.OwnerReferences = []meta.OwnerReference{{APIVersion: "v1", Kind: "Service", Name: "service"}}
+		assert.False(t, h.compareIngresses(des, cur))
+	})
 	t.Run("compare ingresses with new spec", func(t *testing.T) {
 		cur := ingress.DeepCopy()
 		des := ingress.DeepCopy()
@@ -227,75 +422,222 @@ func Test_Handler(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2})
-		_ = kube.GetClientSet(ctx, logger)
-		h.services, _ = h.fetchServices()
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
 		l, err := h.buildDesiredIngresses()
 		assert.NoError(t, err)
 		assert.Len(t, l, 1)
-		assert.Len(t, l["www-example-com"].Spec.Rules[0].HTTP.Paths, 2)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 2)
+		assert.Len(t, l["default/www-example-com"].OwnerReferences, 2)
+		assert.Equal(t, "service,service2", l["default/www-example-com"].Annotations[viper.GetString(config.IngressOwnersAnnotation)])
 	})
 	t.Run("build desired ingress with multiple hosts", func(t *testing.T) {
 		s := service.DeepCopy()
 		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,www2.example.com"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{s})
-		_ = kube.GetClientSet(ctx, logger)
-		h.services, _ = h.fetchServices()
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
 		l, err := h.buildDesiredIngresses()
 		assert.NoError(t, err)
 		assert.Len(t, l, 1)
-		assert.Len(t, l["www-example-com"].Spec.Rules, 2)
-		assert.Len(t, l["www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
-		assert.Len(t, l["www-example-com"].Spec.Rules[1].HTTP.Paths, 1)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules, 2)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[1].HTTP.Paths, 1)
 	})
 
-	t.Run("build desired ingresses with namespace mismatch error", func(t *testing.T) {
+	t.Run("build desired ingresses with host claimed by another namespace", func(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Namespace = "alternative"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2})
-		_ = kube.GetClientSet(ctx, logger)
-		h.services, _ = h.fetchServices()
-		_, err := h.buildDesiredIngresses()
+		s2.CreationTimestamp = meta.NewTime(time.Now())
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		h.services, _ = h.fetchServices("alternative/www-example-com")
+		l, err = h.buildDesiredIngresses()
 		assert.Error(t, err)
+		assert.Len(t, l, 0)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service alternative/service2")
+	})
+	t.Run("build desired ingresses with same named services in different namespaces", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Namespace = "alternative"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www2.example.com"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "default", l["default/www-example-com"].Namespace)
+		h.services, _ = h.fetchServices("alternative/www2-example-com")
+		l, err = h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "alternative", l["alternative/www2-example-com"].Namespace)
 	})
 	t.Run("build desired ingresses with ingress class mismatch error", func(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Annotations[viper.GetString(config.IngressClassAnnotation)] = "alternative"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2})
-		_ = kube.GetClientSet(ctx, logger)
-		h.services, _ = h.fetchServices()
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
 		_, err := h.buildDesiredIngresses()
 		assert.Error(t, err)
 	})
 
+	t.Run("build desired ingresses with tls disabled", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSAnnotation)] = "false"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.TLS, 0)
+	})
+	t.Run("build desired ingresses with tls forced", func(t *testing.T) {
+		viper.Set(config.IngressEnableTLS, false)
+		defer viper.Set(config.IngressEnableTLS, true)
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSAnnotation)] = "true"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.TLS, 1)
+	})
+	t.Run("build desired ingresses with tls mismatch error", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
+		s2.Annotations[viper.GetString(config.IngressTLSAnnotation)] = "false"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.TLS, 1)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring tls false")
+	})
+	t.Run("build desired ingresses with invalid tls", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSAnnotation)] = "maybe"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l, 0)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidTLS service default/service has invalid tls setting maybe")
+	})
+
+	t.Run("build desired ingresses with mixed wildcard and exact hosts", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "*.apps.example.com,apps.example.com,www.example.com"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/wildcard-apps-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		i := l["default/wildcard-apps-example-com"]
+		assert.Len(t, i.Spec.Rules, 3)
+		assert.Equal(t, "*.apps.example.com", i.Spec.Rules[0].Host)
+		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 1)
+		assert.Equal(t, []networking.IngressTLS{
+			{Hosts: []string{"*.apps.example.com"}, SecretName: "apps.example.com-wildcard-tls"},
+			{Hosts: []string{"apps.example.com", "www.example.com"}, SecretName: "wildcard-apps-example-com-tls"},
+		}, i.Spec.TLS)
+	})
+	t.Run("build desired ingresses with wildcard host added by grouped service", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressNameAnnotation)] = "apps"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "*.apps.example.com"
+		s2.Annotations[viper.GetString(config.IngressNameAnnotation)] = "apps"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/apps")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Equal(t, []networking.IngressTLS{
+			{Hosts: []string{"www.example.com"}, SecretName: "apps-tls"},
+			{Hosts: []string{"*.apps.example.com"}, SecretName: "apps.example.com-wildcard-tls"},
+		}, l["default/apps"].Spec.TLS)
+	})
+	t.Run("build desired ingresses with invalid host", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.*.example.com"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-wildcard-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l, 0)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidHost service default/service has invalid host www.*.example.com")
+	})
+
+	t.Run("build desired ingresses with portless service", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Spec.Ports = nil
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidPort service default/service2 has no ports")
+	})
+
+	t.Run("build desired ingresses with invalid path", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "api:Prefix"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidPath")
+	})
+
+	t.Run("build desired ingresses with gateway protocol", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressProtocolAnnotation)] = "grpc"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidProtocol service default/service2 has protocol grpc, which requires gateway output")
+	})
+
 	t.Run("create ingress", func(t *testing.T) {
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger)
 		i := ingress.DeepCopy()
 		i.Name = "new-ingress"
+		count := testutil.ToFloat64(metrics.IngressOperations.WithLabelValues("create"))
 		_, err := h.createIngress(i)
 		assert.NoError(t, err)
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.IngressOperations.WithLabelValues("create")))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Created")
 	})
 	t.Run("create ingress with error", func(t *testing.T) {
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger)
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("create", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
 		i := ingress.DeepCopy()
+		count := testutil.ToFloat64(metrics.APIErrors.WithLabelValues("create"))
 		_, err := h.createIngress(i)
 		assert.Error(t, err)
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.APIErrors.WithLabelValues("create")))
 	})
 
 	t.Run("update ingress", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger, ingress)
 		i := ingress.DeepCopy()
 		_, err := h.updateIngress(i)
 		assert.NoError(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
 	})
 	t.Run("update ingress with error", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger, ingress)
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("update", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
 		i := ingress.DeepCopy()
@@ -304,15 +646,16 @@ func Test_Handler(t *testing.T) {
 	})
 
 	t.Run("delete ingress", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger, ingress)
 		i := ingress.DeepCopy()
+		count := testutil.ToFloat64(metrics.IngressOperations.WithLabelValues("delete"))
 		err := h.deleteIngress(i)
 		assert.NoError(t, err)
+		assert.Equal(t, count+1, testutil.ToFloat64(metrics.IngressOperations.WithLabelValues("delete")))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Deleted")
 	})
 	t.Run("delete ingress with error", func(t *testing.T) {
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{ingress})
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger, ingress)
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("delete", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
 		i := ingress.DeepCopy()
@@ -324,9 +667,10 @@ func Test_Handler(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy()})
-		_ = kube.GetClientSet(ctx, logger)
-		assert.NoError(t, h.reconcile())
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, ingress.DeepCopy())
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
 	})
 	t.Run("reconcile updating ingress", func(t *testing.T) {
 		s2 := service.DeepCopy()
@@ -334,40 +678,112 @@ func Test_Handler(t *testing.T) {
 		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
 		i2 := ingress.DeepCopy()
 		i2.Name = "www-example-com"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy(), i2})
-		_ = kube.GetClientSet(ctx, logger)
-		assert.NoError(t, h.reconcile())
-
+		i2.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, ingress.DeepCopy(), i2)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		i, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 2)
 	})
-	t.Run("reconcile with error fetching services", func(t *testing.T) {
-		_ = kube.GetClientSet(ctx, logger)
-		kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).PrependReactor("list", "services", serviceListErrorReactor)
-		defer resetCoreReactionChain()
-		assert.Error(t, h.reconcile())
+	t.Run("reconcile deleting ingress", func(t *testing.T) {
+		i := ingress.DeepCopy()
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.Error(t, err)
 	})
-	t.Run("reconcile with error fetching ingresses", func(t *testing.T) {
-		_ = kube.GetClientSet(ctx, logger)
-		kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).PrependReactor("list", "ingresses", ingressListErrorReactor)
-		defer resetNetworkingReactionChain()
-		assert.Error(t, h.reconcile())
+	t.Run("reconcile keeping ingress of rejected service", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressPortAnnotation)] = "nope"
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, s, i)
+		assert.Error(t, h.reconcile("default/www-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "relative"
+		p := httpIngressPath.DeepCopy()
+		p.Backend.Service.Name = "service2"
+		i.Spec.Rules[0].HTTP.Paths = []networking.HTTPIngressPath{*p}
+		h = newTestHandler(t, ctx, logger, service.DeepCopy(), s2, i)
+		assert.Error(t, h.reconcile("default/www-example-com"))
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Equal(t, "service2", c.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+	})
+	t.Run("reconcile skipping unowned ingress", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), ingress.DeepCopy())
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+	t.Run("reconcile refusing to update unowned ingress", func(t *testing.T) {
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict ingress default/www-example-com is not owned and adoption is disabled")
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Empty(t, c.Annotations[viper.GetString(config.IngressOwnersAnnotation)])
+	})
+	t.Run("reconcile adopting unowned ingress", func(t *testing.T) {
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyForce)
+		defer viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Adopted")
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Equal(t, "service", c.Annotations[viper.GetString(config.IngressOwnersAnnotation)])
+	})
+	t.Run("reconcile deleting unowned ingress", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), ingress.DeepCopy())
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.NoError(t, err)
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyForce)
+		defer viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err = kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.Error(t, err)
 	})
 	t.Run("reconcile with error building desired ingresses", func(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Namespace = "alternative"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2})
-		_ = kube.GetClientSet(ctx, logger)
-		assert.Error(t, h.reconcile())
+		s2.CreationTimestamp = meta.NewTime(time.Now())
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Error(t, h.reconcile("alternative/www-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		_, err = kube.ClientSet.NetworkingV1().Ingresses("alternative").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+	t.Run("reconcile same named ingresses in different namespaces", func(t *testing.T) {
+		i1 := ingress.DeepCopy()
+		i1.Name = "www-example-com"
+		i1.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		i2 := i1.DeepCopy()
+		i2.Namespace = "alternative"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i1, i2)
+		assert.NoError(t, h.reconcile("alternative/www-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		_, err = kube.ClientSet.NetworkingV1().Ingresses("alternative").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Error(t, err)
 	})
 	t.Run("reconcile with error deleting ingresses", func(t *testing.T) {
-		s2 := service.DeepCopy()
-		s2.Name = "service2"
-		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy()})
-		_ = kube.GetClientSet(ctx, logger)
+		i := ingress.DeepCopy()
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("delete", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
-		assert.Error(t, h.reconcile())
+		assert.Error(t, h.reconcile("default/ingress"))
 	})
 	t.Run("reconcile with error updating ingress", func(t *testing.T) {
 		s2 := service.DeepCopy()
@@ -375,44 +791,202 @@ func Test_Handler(t *testing.T) {
 		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
 		i2 := ingress.DeepCopy()
 		i2.Name = "www-example-com"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy(), i2})
-		_ = kube.GetClientSet(ctx, logger)
+		i2.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, ingress.DeepCopy(), i2)
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("update", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
-		assert.Error(t, h.reconcile())
+		assert.Error(t, h.reconcile("default/www-example-com"))
 
 	})
+	t.Run("reconcile refusing to adopt existing ingress", func(t *testing.T) {
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Labels = map[string]string{}
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.NotContains(t, h.currentIngresses, "default/www-example-com")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict ingress default/www-example-com already exists and adoption is disabled")
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Empty(t, c.Labels)
+	})
+	t.Run("reconcile adopting annotated ingress", func(t *testing.T) {
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyAnnotation)
+		defer viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Labels = map[string]string{}
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "missing the ptonini.github.io/ingress-adopt annotation")
+		i.Annotations[viper.GetString(config.IngressAdoptAnnotation)] = "true"
+		h = newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, h.currentIngresses, "default/www-example-com")
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Equal(t, viper.GetString(config.ResourceLabelValue), c.Labels[viper.GetString(config.ResourceLabelKey)])
+		assert.Equal(t, "service", c.Annotations[viper.GetString(config.IngressOwnersAnnotation)])
+	})
+	t.Run("reconcile forcing adoption of existing ingress", func(t *testing.T) {
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyForce)
+		defer viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Labels = map[string]string{}
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Adopted")
+	})
+	t.Run("reconcile updating own ingress out of the selector", func(t *testing.T) {
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Labels = map[string]string{}
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, h.currentIngresses, "default/www-example-com")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Normal Updated")
+		assert.Len(t, h.recorder.(*record.FakeRecorder).Events, 0)
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Equal(t, viper.GetString(config.ResourceLabelValue), c.Labels[viper.GetString(config.ResourceLabelKey)])
+	})
+	t.Run("reconcile refusing to adopt ingress managed by another instance", func(t *testing.T) {
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyForce)
+		defer viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		i := ingress.DeepCopy()
+		i.Name = "www-example-com"
+		i.Labels[viper.GetString(config.InstanceLabelKey)] = "other"
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), i)
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "is managed by another instance")
+		c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Equal(t, "other", c.Labels[viper.GetString(config.InstanceLabelKey)])
+	})
 	t.Run("reconcile with error creating ingresses", func(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy()})
-		_ = kube.GetClientSet(ctx, logger)
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, ingress.DeepCopy())
 		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("create", "ingresses", ingressErrorReactor)
 		defer resetNetworkingReactionChain()
-		assert.Error(t, h.reconcile())
+		assert.Error(t, h.reconcile("default/www-example-com"))
 	})
 
-	t.Run("reconciliation loop", func(t *testing.T) {
-		_ = kube.GetClientSet(ctx, logger)
-		viper.Set(config.CheckInterval, 0)
+	t.Run("enqueue service", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		h.enqueueService(service.DeepCopy())
+		h.enqueueService(cache.DeletedFinalStateUnknown{Obj: service.DeepCopy()})
+		assert.Equal(t, 1, h.queue.Len())
+	})
+	t.Run("enqueue service sharing host with another namespace", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Namespace = "alternative"
+		h := newTestHandler(t, ctx, logger, s2)
+		for h.queue.Len() > 0 {
+			key, _ := h.queue.Get()
+			h.queue.Done(key)
+		}
+		h.enqueueService(service.DeepCopy())
+		assert.Equal(t, 2, h.queue.Len())
+	})
+	t.Run("enqueue ingress", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger)
+		h.enqueueIngress(ingress.DeepCopy())
+		h.enqueueIngress(cache.DeletedFinalStateUnknown{Obj: ingress.DeepCopy()})
+		assert.Equal(t, 1, h.queue.Len())
+	})
+	t.Run("resync", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), ingress.DeepCopy())
+		for h.queue.Len() > 0 {
+			key, _ := h.queue.Get()
+			h.queue.Done(key)
+		}
+		h.resync()
+		assert.Equal(t, 2, h.queue.Len())
+	})
+
+	t.Run("process items with error", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www2.example.com"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		kube.ClientSet.NetworkingV1().(*networkingFake.FakeNetworkingV1).PrependReactor("create", "ingresses", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+			if action.(k8sTesting.CreateAction).GetObject().(*networking.Ingress).Name == "www-example-com" {
+				return ingressErrorReactor(action)
+			}
+			return false, nil, nil
+		})
+		defer resetNetworkingReactionChain()
+		assert.True(t, h.processNextItem())
+		assert.True(t, h.processNextItem())
+		assert.Equal(t, 1, h.queue.NumRequeues("default/www-example-com"))
+		assert.Equal(t, 0, h.queue.NumRequeues("default/www2-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www2-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+		assert.Greater(t, testutil.ToFloat64(metrics.LastSuccessfulReconcile), float64(0))
+	})
+	t.Run("update managed gauges", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, ingress.DeepCopy())
+		h.updateManagedGauges()
+		assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ManagedServices))
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ManagedIngresses))
+	})
+
+	t.Run("run", func(t *testing.T) {
 		before := time.Now()
-		h.ReconciliationLoop()
+		h := newTestHandler(t, ctx, logger)
+		go h.Run()
+		_, _ = kube.ClientSet.CoreV1().Services("default").Create(ctx, service.DeepCopy(), meta.CreateOptions{})
+		assert.Eventually(t, func() bool {
+			_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+			return err == nil
+		}, 5*time.Second, 100*time.Millisecond)
 		errorLogs := observedLogs.FilterLevelExact(zapcore.Level(2)).Filter(func(e observer.LoggedEntry) bool { return e.Time.After(before) }).All()
 		assert.Len(t, errorLogs, 0)
 	})
-	t.Run("reconciliation loop with error", func(t *testing.T) {
+	t.Run("run with reconcile error", func(t *testing.T) {
 		s2 := service.DeepCopy()
 		s2.Name = "service2"
 		s2.Namespace = "alternative"
-		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
-		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{service.DeepCopy(), s2, ingress.DeepCopy()})
+		before := time.Now()
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		go h.Run()
+		assert.Eventually(t, func() bool {
+			errorLogs := observedLogs.FilterLevelExact(zapcore.Level(2)).Filter(func(e observer.LoggedEntry) bool { return e.Time.After(before) }).All()
+			return len(errorLogs) >= 1
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+	t.Run("run with cache sync error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{}))
+		defer cancel()
 		_ = kube.GetClientSet(ctx, logger)
-		viper.Set(config.CheckInterval, 0)
+		kube.ClientSet.CoreV1().(*coreFake.FakeCoreV1).PrependReactor("list", "services", serviceListErrorReactor)
+		viper.Set(config.ClientTimeout, 1)
+		defer viper.Set(config.ClientTimeout, 60)
 		before := time.Now()
-		h.ReconciliationLoop()
-		errorLogs := observedLogs.FilterLevelExact(zapcore.Level(2)).Filter(func(e observer.LoggedEntry) bool { return e.Time.After(before) }).All()
-		assert.GreaterOrEqual(t, len(errorLogs), 1)
+		h := Factory(ctx, logger, viper.GetInt64(config.ClientTimeout))
+		h.Run()
+		cancel()
+		h.shutdownInformers()
+		errorLogs := observedLogs.FilterMessageSnippet("error syncing").Filter(func(e observer.LoggedEntry) bool { return e.Time.After(before) }).All()
+		assert.Len(t, errorLogs, 1)
+	})
+	t.Run("validate config", func(t *testing.T) {
+		assert.NoError(t, ValidateConfig())
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Missing }}")
+		assert.Error(t, ValidateConfig())
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Name ")
+		assert.Error(t, ValidateConfig())
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Name }}-tls")
+		viper.Set(config.IngressAdoptionPolicy, "always")
+		assert.Error(t, ValidateConfig())
+		viper.Set(config.IngressAdoptionPolicy, config.AdoptionPolicyRefuse)
+		viper.Set(config.OutputMode, config.OutputModeGateway)
+		defer viper.Set(config.OutputMode, config.OutputModeIngress)
+		assert.Error(t, ValidateConfig())
 	})
 
 }
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"slices"
+	"strings"
+)
+
+// instanceRequirement selects the objects stamped by this instance. Unnamed instances select objects
+// without an instance label, so they never touch objects of named instances.
+func instanceRequirement() (*labels.Requirement, error) {
+	key, instance := viper.GetString(config.InstanceLabelKey), viper.GetString(config.InstanceName)
+	if instance == "" {
+		return labels.NewRequirement(key, selection.DoesNotExist, nil)
+	}
+	return labels.NewRequirement(key, selection.Equals, []string{instance})
+}
+
+func validateInstance() error {
+	if errs := validation.IsQualifiedName(viper.GetString(config.InstanceLabelKey)); len(errs) > 0 {
+		return fmt.Errorf("invalid %s: %s", config.InstanceLabelKey, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(viper.GetString(config.InstanceName)); len(errs) > 0 {
+		return fmt.Errorf("invalid %s: %s", config.InstanceName, strings.Join(errs, ", "))
+	}
+	// Unnamed instances select each other's ingresses, so sharded instances would delete them
+	if len(splitSetting(config.InstanceClasses)) > 0 && viper.GetString(config.InstanceName) == "" {
+		return fmt.Errorf("%s requires %s", config.InstanceClasses, config.InstanceName)
+	}
+	return nil
+}
+
+// handlesClass reports whether the ingress class belongs to the classes of this instance, taking
+// the default class for services without one. Instances without classes handle every class.
+func handlesClass(class string) bool {
+	classes := splitSetting(config.InstanceClasses)
+	if len(classes) == 0 {
+		return true
+	}
+	if class == "" {
+		class = viper.GetString(config.InstanceDefaultClass)
+	}
+	return slices.Contains(classes, class)
+}
+
+// watchesService filters services by namespace and by class, sharding services between instances
+func (h *Handler) watchesService(o meta.Object) bool {
+	return h.watchesObject(o) && handlesClass(o.GetAnnotations()[viper.GetString(config.IngressClassAnnotation)])
+}
+
+// isOwnedObject reports whether the bot created the object, and it belongs to this instance
+func isOwnedObject(o meta.Object) bool {
+	if o.GetAnnotations()[viper.GetString(config.IngressOwnersAnnotation)] == "" {
+		return false
+	}
+	return o.GetLabels()[viper.GetString(config.InstanceLabelKey)] == viper.GetString(config.InstanceName)
+}
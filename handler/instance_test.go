@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func Test_Instance(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, _ := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	instanceKey := viper.GetString(config.InstanceLabelKey)
+	unnamed := ingress.DeepCopy()
+	unnamed.Name = "www-example-com"
+	unnamed.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	unnamed.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+	internal := unnamed.DeepCopy()
+	internal.Labels[instanceKey] = "internal"
+
+	t.Run("instance requirement", func(t *testing.T) {
+		r, err := instanceRequirement()
+		assert.NoError(t, err)
+		assert.Equal(t, "!ptonini.github.io/ingress-bot-instance", r.String())
+		viper.Set(config.InstanceName, "public")
+		defer viper.Set(config.InstanceName, "")
+		r, err = instanceRequirement()
+		assert.NoError(t, err)
+		assert.Equal(t, "ptonini.github.io/ingress-bot-instance=public", r.String())
+		selector, err := outputSelector()
+		assert.NoError(t, err)
+		assert.Equal(t, "ptonini.github.io/ingress-bot=true,ptonini.github.io/ingress-bot-instance=public", selector.String())
+	})
+	t.Run("validate instance", func(t *testing.T) {
+		assert.NoError(t, validateInstance())
+		viper.Set(config.InstanceName, "public ingresses")
+		defer viper.Set(config.InstanceName, "")
+		assert.Error(t, validateInstance())
+		assert.Error(t, ValidateConfig())
+	})
+	t.Run("validate instance classes", func(t *testing.T) {
+		viper.Set(config.InstanceClasses, "public")
+		defer viper.Set(config.InstanceClasses, "")
+		assert.Error(t, validateInstance())
+		viper.Set(config.InstanceName, "public")
+		defer viper.Set(config.InstanceName, "")
+		assert.NoError(t, validateInstance())
+	})
+
+	t.Run("is owned object", func(t *testing.T) {
+		assert.True(t, isOwnedObject(unnamed))
+		assert.False(t, isOwnedObject(internal))
+		assert.False(t, isOwnedObject(ingress))
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		assert.False(t, isOwnedObject(unnamed))
+		assert.True(t, isOwnedObject(internal))
+	})
+
+	t.Run("build ingress with instance", func(t *testing.T) {
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		h := newTestHandler(t, ctx, logger)
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", true)
+		assert.Equal(t, "internal", i.Labels[instanceKey])
+		assert.True(t, h.ingressSelector.Matches(managedLabels()))
+	})
+
+	t.Run("fetch ingresses of instance", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, internal.DeepCopy())
+		l, err := h.fetchIngresses("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 0)
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		i := unnamed.DeepCopy()
+		i.Name = "unnamed"
+		h = newTestHandler(t, ctx, logger, internal.DeepCopy(), i)
+		l, err = h.fetchIngresses("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "internal", l["default/www-example-com"].Labels[instanceKey])
+	})
+
+	t.Run("reconcile skipping ingress of another instance", func(t *testing.T) {
+		viper.Set(config.InstanceName, "public")
+		defer viper.Set(config.InstanceName, "")
+		i := internal.DeepCopy()
+		i.Name = "ingress"
+		h := newTestHandler(t, ctx, logger, i)
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+	t.Run("reconcile deleting ingress of instance", func(t *testing.T) {
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		i := internal.DeepCopy()
+		i.Name = "ingress"
+		h := newTestHandler(t, ctx, logger, i)
+		assert.NoError(t, h.reconcile("default/ingress"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("handles class", func(t *testing.T) {
+		assert.True(t, handlesClass(""))
+		assert.True(t, handlesClass("private"))
+		viper.Set(config.InstanceClasses, "public, public-v2")
+		defer viper.Set(config.InstanceClasses, "")
+		assert.True(t, handlesClass("public-v2"))
+		assert.False(t, handlesClass("private"))
+		assert.False(t, handlesClass(""))
+		viper.Set(config.InstanceDefaultClass, "public")
+		defer viper.Set(config.InstanceDefaultClass, "")
+		assert.True(t, handlesClass(""))
+	})
+
+	t.Run("fetch services of instance classes", func(t *testing.T) {
+		viper.Set(config.InstanceClasses, "public")
+		defer viper.Set(config.InstanceClasses, "")
+		viper.Set(config.InstanceDefaultClass, "public")
+		defer viper.Set(config.InstanceDefaultClass, "")
+		s := service.DeepCopy()
+		delete(s.Annotations, viper.GetString(config.IngressClassAnnotation))
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressClassAnnotation)] = "public"
+		s3 := service.DeepCopy()
+		s3.Name = "service3"
+		s3.Annotations[viper.GetString(config.IngressClassAnnotation)] = "private"
+		h := newTestHandler(t, ctx, logger, s, s2, s3)
+		assert.True(t, h.watchesService(s))
+		assert.False(t, h.watchesService(s3))
+		l, err := h.fetchServices("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 2)
+		assert.NotContains(t, l, "default/service3")
+	})
+	t.Run("reconcile deleting ingress of service moved to another class", func(t *testing.T) {
+		viper.Set(config.InstanceClasses, "public")
+		defer viper.Set(config.InstanceClasses, "")
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressClassAnnotation)] = "private"
+		h := newTestHandler(t, ctx, logger, s, internal.DeepCopy())
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		_, err := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.Error(t, err)
+	})
+
+}
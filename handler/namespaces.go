@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	apiMeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"slices"
+	"strings"
+)
+
+// lister is the part of the generated listers the handler relies on
+type lister[T any] interface {
+	List(selector labels.Selector) ([]T, error)
+}
+
+// namespacedLister lists objects from the informers of every watched namespace, leaving out
+// the objects filtered by the handler
+type namespacedLister[T any] struct {
+	listers  []lister[T]
+	indexers []cache.Indexer
+	watches  func(o meta.Object) bool
+}
+
+func (l *namespacedLister[T]) List(selector labels.Selector) ([]T, error) {
+	var list []T
+	for _, v := range l.listers {
+		items, err := v.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range items {
+			if m, err := apiMeta.Accessor(o); err == nil && l.watches(m) {
+				list = append(list, o)
+			}
+		}
+	}
+	return list, nil
+}
+
+// ByIndex looks objects up on the indexers of every watched namespace
+func (l *namespacedLister[T]) ByIndex(index string, value string) ([]T, error) {
+	var list []T
+	for _, v := range l.indexers {
+		items, err := v.ByIndex(index, value)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range items {
+			if t, ok := o.(T); ok {
+				if m, err := apiMeta.Accessor(o); err == nil && l.watches(m) {
+					list = append(list, t)
+				}
+			}
+		}
+	}
+	return list, nil
+}
+
+// GetByKey looks an object up by its namespace/name key on the indexers of every watched namespace
+func (l *namespacedLister[T]) GetByKey(key string) (item T, exists bool, err error) {
+	for _, v := range l.indexers {
+		o, ok, err := v.GetByKey(key)
+		if err != nil {
+			return item, false, err
+		}
+		if t, isT := o.(T); ok && isT {
+			if m, err := apiMeta.Accessor(o); err == nil && l.watches(m) {
+				return t, true, nil
+			}
+		}
+	}
+	return item, false, nil
+}
+
+func splitSetting(setting string) []string {
+	var values []string
+	for _, v := range strings.Split(viper.GetString(setting), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// watchedNamespaces returns the namespaces to run informers on. Listing namespaces explicitly runs
+// the bot in namespaced mode, where a role on each namespace is enough, otherwise every namespace
+// is watched.
+func watchedNamespaces() []string {
+	namespaces := splitSetting(config.WatchNamespaces)
+	if len(namespaces) == 0 {
+		return []string{meta.NamespaceAll}
+	}
+	return slices.DeleteFunc(namespaces, func(ns string) bool {
+		return slices.Contains(splitSetting(config.ExcludeNamespaces), ns)
+	})
+}
+
+func namespaceSelector() (labels.Selector, error) {
+	selector, err := labels.Parse(viper.GetString(config.NamespaceSelector))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", config.NamespaceSelector, err)
+	}
+	return selector, nil
+}
+
+// watchesNamespace filters out excluded namespaces, and namespaces out of the selector when set
+func (h *Handler) watchesNamespace(namespace string) bool {
+	if slices.Contains(splitSetting(config.ExcludeNamespaces), namespace) {
+		return false
+	}
+	if h.namespaceLister == nil {
+		return true
+	}
+	n, err := h.namespaceLister.Get(namespace)
+	return err == nil && h.namespaceSelector.Matches(labels.Set(n.Labels))
+}
+
+func (h *Handler) watchesObject(o meta.Object) bool {
+	return h.watchesNamespace(o.GetNamespace())
+}
+
+// enqueueNamespace requeues the services of a namespace entering the selector. Namespaces leaving
+// the selector are hidden from every lister along with their services and ingresses, so the bot
+// stops managing them and leaves their ingresses in place, as it does for excluded namespaces.
+func (h *Handler) enqueueNamespace(obj interface{}) {
+	n, ok := obj.(*core.Namespace)
+	if !ok {
+		return
+	}
+	services, _ := h.serviceLister.List(labels.Everything())
+	for _, s := range services {
+		if s.Namespace == n.Name {
+			h.enqueueService(s)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"testing"
+)
+
+func Test_Namespaces(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	s2 := service.DeepCopy()
+	s2.Namespace = "alternative"
+	s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www2.example.com"
+	namespace := &core.Namespace{ObjectMeta: meta.ObjectMeta{Name: "default", Labels: map[string]string{"team": "platform"}}}
+	namespace2 := &core.Namespace{ObjectMeta: meta.ObjectMeta{Name: "alternative"}}
+
+	t.Run("watched namespaces", func(t *testing.T) {
+		assert.Equal(t, []string{""}, watchedNamespaces())
+		viper.Set(config.WatchNamespaces, "default, alternative,kube-system")
+		defer viper.Set(config.WatchNamespaces, "")
+		viper.Set(config.ExcludeNamespaces, "kube-system")
+		defer viper.Set(config.ExcludeNamespaces, "")
+		assert.Equal(t, []string{"default", "alternative"}, watchedNamespaces())
+		assert.NoError(t, ValidateConfig())
+		viper.Set(config.WatchNamespaces, "kube-system")
+		assert.Error(t, ValidateConfig())
+	})
+	t.Run("validate namespace selector", func(t *testing.T) {
+		viper.Set(config.NamespaceSelector, "team in (platform")
+		defer viper.Set(config.NamespaceSelector, "")
+		assert.Error(t, ValidateConfig())
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), namespace)
+		l, _ := h.serviceLister.List(labels.Everything())
+		assert.Len(t, l, 0)
+		assert.Len(t, observedLogs.FilterMessageSnippet("invalid NAMESPACE_SELECTOR").All(), 1)
+	})
+
+	t.Run("namespaced mode", func(t *testing.T) {
+		viper.Set(config.WatchNamespaces, "default")
+		defer viper.Set(config.WatchNamespaces, "")
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		assert.Len(t, h.informers, 1)
+		assert.Contains(t, h.waitForCacheSync(ctx.Done()), "default/*v1.Service")
+		l, err := h.serviceLister.List(labels.Everything())
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "default", l[0].Namespace)
+	})
+	t.Run("namespaced mode with multiple namespaces", func(t *testing.T) {
+		viper.Set(config.WatchNamespaces, "default,alternative")
+		defer viper.Set(config.WatchNamespaces, "")
+		i := ingress.DeepCopy()
+		i.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, i)
+		assert.Len(t, h.informers, 2)
+		l, err := h.serviceLister.List(labels.Everything())
+		assert.NoError(t, err)
+		assert.Len(t, l, 2)
+		ingresses, err := h.fetchIngresses("default/ingress")
+		assert.NoError(t, err)
+		assert.Len(t, ingresses, 1)
+	})
+
+	t.Run("excluded namespaces", func(t *testing.T) {
+		viper.Set(config.ExcludeNamespaces, "alternative")
+		defer viper.Set(config.ExcludeNamespaces, "")
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		assert.True(t, h.watchesNamespace("default"))
+		assert.False(t, h.watchesNamespace("alternative"))
+		l, err := h.fetchServices("alternative/www2-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 0)
+	})
+
+	t.Run("namespace selector", func(t *testing.T) {
+		viper.Set(config.NamespaceSelector, "team=platform")
+		defer viper.Set(config.NamespaceSelector, "")
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2, namespace, namespace2)
+		assert.True(t, h.watchesNamespace("default"))
+		assert.False(t, h.watchesNamespace("alternative"))
+		l, err := h.serviceLister.List(labels.Everything())
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Equal(t, "default", l[0].Namespace)
+	})
+	t.Run("enqueue namespace", func(t *testing.T) {
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		for h.queue.Len() > 0 {
+			key, _ := h.queue.Get()
+			h.queue.Done(key)
+		}
+		h.enqueueNamespace(namespace2)
+		assert.Equal(t, 1, h.queue.Len())
+		key, _ := h.queue.Get()
+		assert.Equal(t, "alternative/www2-example-com", key)
+		h.queue.Done(key)
+		h.enqueueNamespace(service.DeepCopy())
+		assert.Equal(t, 0, h.queue.Len())
+	})
+	t.Run("reconcile namespace out of the selector", func(t *testing.T) {
+		viper.Set(config.NamespaceSelector, "team=platform")
+		defer viper.Set(config.NamespaceSelector, "")
+		i := ingress.DeepCopy()
+		i.Name = "www2-example-com"
+		i.Namespace = "alternative"
+		i.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+		i.Annotations[viper.GetString(config.IngressOwnersAnnotation)] = "service"
+		h := newTestHandler(t, ctx, logger, s2, i, namespace, namespace2)
+		l, err := h.fetchIngresses("alternative/www2-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 0)
+		assert.NoError(t, h.reconcile("alternative/www2-example-com"))
+		_, err = kube.ClientSet.NetworkingV1().Ingresses("alternative").Get(ctx, "www2-example-com", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+
+}
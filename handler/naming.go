@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]")
+
+type ingressNameData struct {
+	Namespace string
+	Service   string
+	Host      string
+}
+
+func parseIngressNameTemplate() (*template.Template, error) {
+	return template.New("name").Option("missingkey=error").Parse(viper.GetString(config.IngressNameTemplate))
+}
+
+// safeName turns any string into a valid DNS-1123 label. Wildcards are spelled out, other invalid
+// characters become dashes, and names too long are truncated with a hash of the original value.
+func safeName(v string) string {
+	name := strings.ReplaceAll(strings.ToLower(v), "*", "wildcard")
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-")
+	if len(name) > 0 && len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(v))
+	hash := hex.EncodeToString(sum[:])[:8]
+	if name == "" {
+		return "ingress-" + hash
+	}
+	return strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(hash)-1], "-") + "-" + hash
+}
+
+// getIngressName returns the name of the ingress serving the service, taken from the name
+// annotation so services can be grouped explicitly, or rendered from the name template
+func (h *Handler) getIngressName(s *core.Service, host string) string {
+	if v := s.Annotations[viper.GetString(config.IngressNameAnnotation)]; v != "" {
+		return safeName(v)
+	}
+	var b strings.Builder
+	t, err := parseIngressNameTemplate()
+	if err == nil {
+		err = t.Execute(&b, ingressNameData{Namespace: s.Namespace, Service: s.Name, Host: host})
+	}
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("error rendering ingress name for service %s/%s: %v", s.Namespace, s.Name, err))
+		return safeName(host)
+	}
+	return safeName(b.String())
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Naming(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	h := newTestHandler(t, ctx, logger)
+
+	t.Run("safe name", func(t *testing.T) {
+		assert.Equal(t, "www-example-com", safeName("www.example.com"))
+		assert.Equal(t, "wildcard-example-com", safeName("*.example.com"))
+		assert.Equal(t, "my-app-example-com", safeName("My_App.example.com."))
+		assert.Regexp(t, "^ingress-[0-9a-f]{8}$", safeName(""))
+		long := strings.Repeat("subdomain.", 10) + "example.com"
+		name := safeName(long)
+		assert.Len(t, name, validation.DNS1123LabelMaxLength)
+		assert.Empty(t, validation.IsDNS1123Label(name))
+		assert.NotEqual(t, name, safeName(strings.Repeat("subdomain.", 11)+"example.com"))
+	})
+
+	t.Run("get ingress name", func(t *testing.T) {
+		s := service.DeepCopy()
+		assert.Equal(t, "www-example-com", h.getIngressName(s, "www.example.com"))
+		viper.Set(config.IngressNameTemplate, "{{ .Namespace }}-{{ .Service }}")
+		defer viper.Set(config.IngressNameTemplate, "{{ .Host }}")
+		assert.Equal(t, "default-service", h.getIngressName(s, "www.example.com"))
+		s.Annotations[viper.GetString(config.IngressNameAnnotation)] = "Shared"
+		assert.Equal(t, "shared", h.getIngressName(s, "www.example.com"))
+	})
+	t.Run("get ingress name with invalid template", func(t *testing.T) {
+		viper.Set(config.IngressNameTemplate, "{{ .Missing }}")
+		defer viper.Set(config.IngressNameTemplate, "{{ .Host }}")
+		assert.Equal(t, "www-example-com", h.getIngressName(service.DeepCopy(), "www.example.com"))
+		assert.Len(t, observedLogs.FilterMessageSnippet("error rendering ingress name").All(), 1)
+		assert.Error(t, ValidateConfig())
+	})
+
+	t.Run("build desired ingresses with grouped services", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressNameAnnotation)] = "shared"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "api.example.com"
+		s2.Annotations[viper.GetString(config.IngressNameAnnotation)] = "shared"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/shared")
+		assert.Len(t, h.services, 2)
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		i := l["default/shared"]
+		assert.Len(t, i.Spec.Rules, 2)
+		assert.Equal(t, "service", i.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+		assert.Len(t, i.Spec.Rules[0].HTTP.Paths, 1)
+		assert.Equal(t, "service2", i.Spec.Rules[1].HTTP.Paths[0].Backend.Service.Name)
+		assert.Len(t, i.Spec.Rules[1].HTTP.Paths, 1)
+		assert.Equal(t, []string{"www.example.com", "api.example.com"}, i.Spec.TLS[0].Hosts)
+		assert.Equal(t, "shared-tls", i.Spec.TLS[0].SecretName)
+	})
+	t.Run("build desired ingresses with host shared by another ingress", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.CreationTimestamp = meta.NewTime(time.Now())
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "api.example.com,www.example.com"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		h.services, _ = h.fetchServices("default/api-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l["default/api-example-com"].Spec.Rules, 2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err = h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+	})
+
+}
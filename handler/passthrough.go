@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// reservedAnnotations lists the ingress annotations managed by the bot itself
+func reservedAnnotations() []string {
+	return []string{
+		viper.GetString(config.IngressOwnersAnnotation),
+		viper.GetString(config.IngressManagedAnnotation),
+		viper.GetString(config.IngressIssuerAnnotation),
+		viper.GetString(config.IngressTLSSecretAnnotation),
+		viper.GetString(config.RouteIngressAnnotation),
+	}
+}
+
+// parseAnnotationPolicy compiles a comma separated list of annotation keys or regular expressions,
+// each matching whole keys
+func parseAnnotationPolicy(setting string) ([]*regexp.Regexp, error) {
+	var policy []*regexp.Regexp
+	for _, entry := range strings.Split(viper.GetString(setting), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		r, err := regexp.Compile("^(?:" + entry + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %s: %v", setting, entry, err)
+		}
+		policy = append(policy, r)
+	}
+	return policy, nil
+}
+
+func matchesAnnotationPolicy(policy []*regexp.Regexp, key string) bool {
+	return slices.ContainsFunc(policy, func(r *regexp.Regexp) bool { return r.MatchString(key) })
+}
+
+// filterAnnotations drops the annotations denied by the policy, or missing from a non-empty
+// allowlist, reporting them on the service
+func (h *Handler) filterAnnotations(s *core.Service, annotations map[string]string) error {
+	allowlist, err := parseAnnotationPolicy(config.IngressAnnotationAllowlist)
+	if err != nil {
+		return err
+	}
+	denylist, err := parseAnnotationPolicy(config.IngressAnnotationDenylist)
+	if err != nil {
+		return err
+	}
+	var rejected []string
+	for k := range annotations {
+		if matchesAnnotationPolicy(denylist, k) || (len(allowlist) > 0 && !matchesAnnotationPolicy(allowlist, k)) {
+			rejected = append(rejected, k)
+			delete(annotations, k)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		h.recorder.Event(s, core.EventTypeWarning, "RejectedAnnotation", fmt.Sprintf("service %s/%s declaring disallowed ingress annotations %s",
+			s.Namespace, s.Name, strings.Join(rejected, ",")))
+	}
+	return nil
+}
+
+// getServicePassthrough collects the service annotations starting with prefix, keyed by the rest of
+// the annotation key. The prefix is prepended to the whole ingress key, so prefixed keys such as
+// nginx.ingress.kubernetes.io/rewrite-target still form valid service annotations.
+func getServicePassthrough(s *core.Service, prefix string) map[string]string {
+	entries := map[string]string{}
+	for k, v := range s.Annotations {
+		if key, ok := strings.CutPrefix(k, prefix); ok && key != "" {
+			entries[key] = v
+		}
+	}
+	return entries
+}
+
+// getServiceIngressMeta returns the annotations and labels the service declares for its ingress
+func (h *Handler) getServiceIngressMeta(s *core.Service) (map[string]string, map[string]string, error) {
+	annotations := getServicePassthrough(s, viper.GetString(config.IngressAnnotationPrefix))
+	if err := h.filterAnnotations(s, annotations); err != nil {
+		return nil, nil, err
+	}
+	for k := range annotations {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, nil, fmt.Errorf("service %s/%s has invalid ingress annotation %s: %s", s.Namespace, s.Name, k, strings.Join(errs, ", "))
+		}
+		for _, r := range reservedAnnotations() {
+			if k == r {
+				return nil, nil, fmt.Errorf("service %s/%s declaring reserved ingress annotation %s", s.Namespace, s.Name, k)
+			}
+		}
+	}
+	labels := getServicePassthrough(s, viper.GetString(config.IngressLabelPrefix))
+	for k, v := range labels {
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...)
+		if len(errs) > 0 {
+			return nil, nil, fmt.Errorf("service %s/%s has invalid ingress label %s=%s: %s", s.Namespace, s.Name, k, v, strings.Join(errs, ", "))
+		}
+		if k == viper.GetString(config.ResourceLabelKey) || k == viper.GetString(config.InstanceLabelKey) {
+			return nil, nil, fmt.Errorf("service %s/%s declaring reserved ingress label %s", s.Namespace, s.Name, k)
+		}
+	}
+	// Ingresses out of the selector would be recreated endlessly
+	merged := managedLabels()
+	maps.Copy(merged, labels)
+	if !h.ingressSelector.Matches(merged) {
+		return nil, nil, fmt.Errorf("service %s/%s declaring ingress labels outside of selector %s", s.Namespace, s.Name, h.ingressSelector)
+	}
+	return annotations, labels, nil
+}
+
+// setIngressMeta merges the annotations and labels declared by a service into the ingress,
+// overriding the global ones
+func (h *Handler) setIngressMeta(i *networking.Ingress, annotations map[string]string, labels map[string]string) {
+	maps.Copy(i.Annotations, annotations)
+	maps.Copy(i.Labels, labels)
+}
+
+// managedKeys lists the annotations and labels the bot sets on an object, so keys it stops setting
+// are told apart from keys added by others
+type managedKeys struct {
+	Annotations []string `json:"annotations,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setManagedKeys records the annotations and labels of a desired object on the object itself
+func setManagedKeys(o meta.Object) {
+	key := viper.GetString(config.IngressManagedAnnotation)
+	annotations := o.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, key)
+	v, _ := json.Marshal(managedKeys{Annotations: sortedKeys(annotations), Labels: sortedKeys(o.GetLabels())})
+	annotations[key] = string(v)
+	o.SetAnnotations(annotations)
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/ptonini/ingress-bot/kube"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"testing"
+)
+
+func Test_Passthrough(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, _ := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	h := newTestHandler(t, ctx, logger)
+
+	annotationPrefix := viper.GetString(config.IngressAnnotationPrefix)
+	labelPrefix := viper.GetString(config.IngressLabelPrefix)
+
+	t.Run("get service passthrough", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/rewrite-target"] = "/"
+		s.Annotations[annotationPrefix] = "empty"
+		assert.Equal(t, map[string]string{"nginx.ingress.kubernetes.io/rewrite-target": "/"}, getServicePassthrough(s, annotationPrefix))
+		assert.Len(t, getServicePassthrough(s, labelPrefix), 0)
+	})
+
+	t.Run("get service ingress meta", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/proxy-body-size"] = "8m"
+		s.Annotations[labelPrefix+"team"] = "platform"
+		annotations, labels, err := h.getServiceIngressMeta(s)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"nginx.ingress.kubernetes.io/proxy-body-size": "8m"}, annotations)
+		assert.Equal(t, map[string]string{"team": "platform"}, labels)
+	})
+	t.Run("get service ingress meta with invalid label", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[labelPrefix+"team"] = "platform team"
+		_, _, err := h.getServiceIngressMeta(s)
+		assert.Error(t, err)
+	})
+	t.Run("get service ingress meta with reserved keys", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+viper.GetString(config.IngressOwnersAnnotation)] = "other"
+		_, _, err := h.getServiceIngressMeta(s)
+		assert.Error(t, err)
+		s = service.DeepCopy()
+		s.Annotations[labelPrefix+viper.GetString(config.ResourceLabelKey)] = "false"
+		_, _, err = h.getServiceIngressMeta(s)
+		assert.Error(t, err)
+	})
+
+	t.Run("parse annotation policy", func(t *testing.T) {
+		policy, err := parseAnnotationPolicy(config.IngressAnnotationDenylist)
+		assert.NoError(t, err)
+		assert.True(t, matchesAnnotationPolicy(policy, "nginx.ingress.kubernetes.io/server-snippet"))
+		assert.False(t, matchesAnnotationPolicy(policy, "nginx.ingress.kubernetes.io/server-snippets"))
+		policy, err = parseAnnotationPolicy(config.IngressAnnotationAllowlist)
+		assert.NoError(t, err)
+		assert.Len(t, policy, 0)
+		viper.Set(config.IngressAnnotationAllowlist, "nginx.ingress.kubernetes.io/rewrite-target, nginx.ingress.kubernetes.io/proxy-.*")
+		defer viper.Set(config.IngressAnnotationAllowlist, "")
+		policy, err = parseAnnotationPolicy(config.IngressAnnotationAllowlist)
+		assert.NoError(t, err)
+		assert.True(t, matchesAnnotationPolicy(policy, "nginx.ingress.kubernetes.io/rewrite-target"))
+		assert.True(t, matchesAnnotationPolicy(policy, "nginx.ingress.kubernetes.io/proxy-body-size"))
+		assert.False(t, matchesAnnotationPolicy(policy, "nginx.ingress.kubernetes.io/auth-url"))
+	})
+	t.Run("parse invalid annotation policy", func(t *testing.T) {
+		viper.Set(config.IngressAnnotationDenylist, "nginx.ingress.kubernetes.io/(server")
+		defer viper.Set(config.IngressAnnotationDenylist, ".*-snippet")
+		_, err := parseAnnotationPolicy(config.IngressAnnotationDenylist)
+		assert.Error(t, err)
+		_, _, err = h.getServiceIngressMeta(service.DeepCopy())
+		assert.Error(t, err)
+		assert.Error(t, ValidateConfig())
+	})
+
+	t.Run("filter annotations", func(t *testing.T) {
+		viper.Set(config.IngressAnnotationAllowlist, "nginx.ingress.kubernetes.io/.*")
+		defer viper.Set(config.IngressAnnotationAllowlist, "")
+		annotations := map[string]string{
+			"nginx.ingress.kubernetes.io/rewrite-target":        "/",
+			"nginx.ingress.kubernetes.io/configuration-snippet": "deny all;",
+			"traefik.ingress.kubernetes.io/router.middlewares":  "auth",
+		}
+		assert.NoError(t, h.filterAnnotations(service.DeepCopy(), annotations))
+		assert.Equal(t, map[string]string{"nginx.ingress.kubernetes.io/rewrite-target": "/"}, annotations)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events,
+			"Warning RejectedAnnotation service default/service declaring disallowed ingress annotations nginx.ingress.kubernetes.io/configuration-snippet,traefik.ingress.kubernetes.io/router.middlewares")
+	})
+
+	t.Run("build desired ingresses with denied annotation", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/rewrite-target"] = "/"
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/server-snippet"] = "return 200;"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Equal(t, "/", l["default/www-example-com"].Annotations["nginx.ingress.kubernetes.io/rewrite-target"])
+		assert.NotContains(t, l["default/www-example-com"].Annotations, "nginx.ingress.kubernetes.io/server-snippet")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning RejectedAnnotation")
+	})
+	t.Run("reconcile removing newly denied annotation", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/configuration-snippet"] = "evil"
+		viper.Set(config.IngressAnnotationDenylist, "")
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, _ := h.buildDesiredIngresses()
+		viper.Set(config.IngressAnnotationDenylist, ".*-snippet")
+		unrecorded := l["default/www-example-com"].DeepCopy()
+		delete(unrecorded.Annotations, viper.GetString(config.IngressManagedAnnotation))
+		for _, i := range []*networking.Ingress{l["default/www-example-com"], unrecorded} {
+			h := newTestHandler(t, ctx, logger, s, i)
+			assert.Equal(t, "evil", i.Annotations["nginx.ingress.kubernetes.io/configuration-snippet"])
+			assert.NoError(t, h.reconcile("default/www-example-com"))
+			c, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+			assert.NotContains(t, c.Annotations, "nginx.ingress.kubernetes.io/configuration-snippet")
+		}
+	})
+	t.Run("build desired ingresses with passthrough", func(t *testing.T) {
+		viper.Set(config.IngressAnnotations, map[string]string{"nginx.ingress.kubernetes.io/proxy-body-size": "1m"})
+		defer viper.Set(config.IngressAnnotations, nil)
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/proxy-body-size"] = "8m"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
+		s2.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/proxy-body-size"] = "8m"
+		s2.Annotations[labelPrefix+"team"] = "platform"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Equal(t, "8m", l["default/www-example-com"].Annotations["nginx.ingress.kubernetes.io/proxy-body-size"])
+		assert.Equal(t, "platform", l["default/www-example-com"].Labels["team"])
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 2)
+	})
+	t.Run("build desired ingresses with passthrough conflict", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/rewrite-target"] = "/"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
+		s2.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/rewrite-target"] = "/$1"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Equal(t, "/", l["default/www-example-com"].Annotations["nginx.ingress.kubernetes.io/rewrite-target"])
+		assert.Len(t, l["default/www-example-com"].Spec.Rules[0].HTTP.Paths, 1)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring annotation nginx.ingress.kubernetes.io/rewrite-target=/$1")
+	})
+	t.Run("build desired ingresses with label conflict", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[labelPrefix+"team"] = "platform"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressPathAnnotation)] = "/path2"
+		s2.Annotations[labelPrefix+"team"] = "data"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		_, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring label team=data")
+	})
+	t.Run("build desired ingresses with invalid passthrough", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"bad key"] = "value"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Len(t, l, 0)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidPassthrough")
+	})
+
+	t.Run("set managed keys", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", true)
+		h.setIngressMeta(i, map[string]string{"nginx.ingress.kubernetes.io/rewrite-target": "/"}, map[string]string{"team": "platform"})
+		setManagedKeys(i)
+		setManagedKeys(i)
+		var keys managedKeys
+		assert.NoError(t, json.Unmarshal([]byte(i.Annotations[viper.GetString(config.IngressManagedAnnotation)]), &keys))
+		assert.Equal(t, []string{"nginx.ingress.kubernetes.io/rewrite-target"}, keys.Annotations)
+		assert.Contains(t, keys.Labels, "team")
+	})
+	t.Run("compare ingresses with removed passthrough", func(t *testing.T) {
+		for _, key := range []string{annotationPrefix + "nginx.ingress.kubernetes.io/rewrite-target", labelPrefix + "team"} {
+			s := service.DeepCopy()
+			s.Annotations[key] = "platform"
+			h := newTestHandler(t, ctx, logger, s)
+			h.services, _ = h.fetchServices("default/www-example-com")
+			l, _ := h.buildDesiredIngresses()
+			cur := l["default/www-example-com"]
+			l, _ = h.buildDesiredIngresses()
+			cur.Annotations["kubectl.kubernetes.io/last-applied-configuration"] = "{}"
+			cur.Labels["other"] = "true"
+			assert.True(t, h.compareIngresses(l["default/www-example-com"], cur))
+			delete(s.Annotations, key)
+			h.services["default/service"] = *s
+			l, _ = h.buildDesiredIngresses()
+			assert.False(t, h.compareIngresses(l["default/www-example-com"], cur))
+		}
+	})
+	t.Run("reconcile removing passthrough", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[annotationPrefix+"nginx.ingress.kubernetes.io/rewrite-target"] = "/"
+		s.Annotations[labelPrefix+"team"] = "platform"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, _ := h.buildDesiredIngresses()
+		h = newTestHandler(t, ctx, logger, service.DeepCopy(), l["default/www-example-com"])
+		assert.NoError(t, h.reconcile("default/www-example-com"))
+		i, _ := kube.ClientSet.NetworkingV1().Ingresses("default").Get(ctx, "www-example-com", meta.GetOptions{})
+		assert.NotContains(t, i.Annotations, "nginx.ingress.kubernetes.io/rewrite-target")
+		assert.NotContains(t, i.Labels, "team")
+	})
+
+}
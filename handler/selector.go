@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
+	"maps"
+)
+
+// defaultSelector matches the resource label with its value
+func defaultSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{viper.GetString(config.ResourceLabelKey): viper.GetString(config.ResourceLabelValue)})
+}
+
+// resourceSelector parses a label selector setting, falling back to the resource label
+func resourceSelector(setting string) (labels.Selector, error) {
+	v := viper.GetString(setting)
+	if v == "" {
+		return defaultSelector(), nil
+	}
+	selector, err := labels.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", setting, err)
+	}
+	return selector, nil
+}
+
+// outputSelector parses the ingress selector, narrowed to the objects of this instance
+func outputSelector() (labels.Selector, error) {
+	selector, err := resourceSelector(config.IngressSelector)
+	if err != nil {
+		return nil, err
+	}
+	r, err := instanceRequirement()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", config.InstanceName, err)
+	}
+	return selector.Add(*r), nil
+}
+
+// managedLabels returns the labels set on every ingress, route and certificate
+func managedLabels() labels.Set {
+	l := labels.Set{viper.GetString(config.ResourceLabelKey): viper.GetString(config.ResourceLabelValue)}
+	if instance := viper.GetString(config.InstanceName); instance != "" {
+		l[viper.GetString(config.InstanceLabelKey)] = instance
+	}
+	if viper.IsSet(config.IngressLabels) {
+		maps.Copy(l, viper.GetStringMapString(config.IngressLabels))
+	}
+	return l
+}
+
+// validateSelectors checks both selectors, and that managed objects are selected, as the bot would
+// otherwise never see the objects it creates
+func validateSelectors() error {
+	if _, err := resourceSelector(config.ServiceSelector); err != nil {
+		return err
+	}
+	if err := validateInstance(); err != nil {
+		return err
+	}
+	selector, err := outputSelector()
+	if err != nil {
+		return err
+	}
+	if !selector.Matches(managedLabels()) {
+		return fmt.Errorf("%s %s does not match the managed labels %s", config.IngressSelector, selector, managedLabels())
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+func Test_Selector(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	t.Run("resource selector", func(t *testing.T) {
+		selector, err := resourceSelector(config.ServiceSelector)
+		assert.NoError(t, err)
+		assert.Equal(t, "ptonini.github.io/ingress-bot=true", selector.String())
+		viper.Set(config.ServiceSelector, "ptonini.github.io/ingress-bot in (true,yes),team")
+		defer viper.Set(config.ServiceSelector, "")
+		selector, err = resourceSelector(config.ServiceSelector)
+		assert.NoError(t, err)
+		assert.Equal(t, "ptonini.github.io/ingress-bot in (true,yes),team", selector.String())
+	})
+	t.Run("resource selector with invalid value", func(t *testing.T) {
+		viper.Set(config.ServiceSelector, "team in (a")
+		defer viper.Set(config.ServiceSelector, "")
+		_, err := resourceSelector(config.ServiceSelector)
+		assert.Error(t, err)
+		assert.Error(t, ValidateConfig())
+		h := newTestHandler(t, ctx, logger)
+		assert.Equal(t, defaultSelector(), h.serviceSelector)
+		assert.Len(t, observedLogs.FilterMessageSnippet("invalid SERVICE_SELECTOR").All(), 1)
+	})
+	t.Run("validate selectors", func(t *testing.T) {
+		assert.NoError(t, validateSelectors())
+		viper.Set(config.IngressSelector, "ptonini.github.io/ingress-bot,team=platform")
+		defer viper.Set(config.IngressSelector, "")
+		assert.Error(t, validateSelectors())
+		viper.Set(config.IngressLabels, map[string]string{"team": "platform"})
+		defer viper.Set(config.IngressLabels, nil)
+		assert.NoError(t, validateSelectors())
+	})
+
+	t.Run("fetch services with disabled label", func(t *testing.T) {
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Labels[viper.GetString(config.ResourceLabelKey)] = "false"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		l, err := h.fetchServices("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Contains(t, l, "default/service")
+	})
+	t.Run("fetch services with custom selector", func(t *testing.T) {
+		viper.Set(config.ServiceSelector, "ptonini.github.io/ingress-bot=true,team in (platform,data)")
+		defer viper.Set(config.ServiceSelector, "")
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Labels["team"] = "platform"
+		h := newTestHandler(t, ctx, logger, service.DeepCopy(), s2)
+		l, err := h.fetchServices("default/www-example-com")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+		assert.Contains(t, l, "default/service2")
+	})
+	t.Run("fetch ingresses with custom selector", func(t *testing.T) {
+		viper.Set(config.IngressSelector, "app.kubernetes.io/managed-by=ingress-bot")
+		defer viper.Set(config.IngressSelector, "")
+		i := ingress.DeepCopy()
+		i.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+		i2 := ingress.DeepCopy()
+		i2.Name = "ingress2"
+		i2.Labels["app.kubernetes.io/managed-by"] = "ingress-bot"
+		h := newTestHandler(t, ctx, logger, i, i2)
+		l, err := h.fetchIngresses("default/ingress")
+		assert.NoError(t, err)
+		assert.Len(t, l, 0)
+		l, err = h.fetchIngresses("default/ingress2")
+		assert.NoError(t, err)
+		assert.Len(t, l, 1)
+	})
+
+	t.Run("get service ingress meta outside of selector", func(t *testing.T) {
+		viper.Set(config.IngressSelector, "ptonini.github.io/ingress-bot=true,team!=legacy")
+		defer viper.Set(config.IngressSelector, "")
+		h := newTestHandler(t, ctx, logger)
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressLabelPrefix)+"team"] = "platform"
+		_, _, err := h.getServiceIngressMeta(s)
+		assert.NoError(t, err)
+		s.Annotations[viper.GetString(config.IngressLabelPrefix)+"team"] = "legacy"
+		_, _, err = h.getServiceIngressMeta(s)
+		assert.Error(t, err)
+	})
+
+}
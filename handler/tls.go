@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// tlsSecretData holds the template fields, where Domain is the host stripped of its wildcard label
+type tlsSecretData struct {
+	Namespace string
+	Name      string
+	Host      string
+	Domain    string
+}
+
+func parseTLSSecretTemplate(setting string) (*template.Template, error) {
+	return template.New("secret").Option("missingkey=error").Parse(viper.GetString(setting))
+}
+
+// getTLSSecretName renders the secret name template for a host of an ingress. Wildcard hosts get
+// a secret of their own, since wildcard certificates are usually issued apart from exact hosts.
+func (h *Handler) getTLSSecretName(name string, namespace string, host string) string {
+	setting := config.IngressTLSSecretTemplate
+	if strings.HasPrefix(host, "*.") {
+		setting = config.IngressWildcardTLSTemplate
+	}
+	var b strings.Builder
+	t, err := parseTLSSecretTemplate(setting)
+	if err == nil {
+		err = t.Execute(&b, tlsSecretData{Namespace: namespace, Name: name, Host: host, Domain: strings.TrimPrefix(host, "*.")})
+	}
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("error rendering tls secret name for ingress %s/%s: %v", namespace, name, err))
+		return fmt.Sprintf("%s-tls", name)
+	}
+	if len(validation.IsDNS1123Subdomain(b.String())) > 0 {
+		return safeName(b.String())
+	}
+	return b.String()
+}
+
+// hostMatches compares a host with a pattern, where a leading wildcard matches a single label
+func hostMatches(pattern string, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+		label, ok := strings.CutSuffix(host, suffix)
+		return ok && label != "" && !strings.Contains(label, ".")
+	}
+	return false
+}
+
+// getServiceTLSSecrets parses a comma separated list of existing secrets, each optionally prefixed
+// with "<host>=", where the host may be a wildcard. Secrets are returned by host, with exact hosts
+// taking precedence over wildcards and wildcards over unqualified secrets.
+func (h *Handler) getServiceTLSSecrets(s *core.Service) (map[string]string, error) {
+	hosts, _, _, _ := h.getServiceAnnotations(s)
+	secrets := map[string]string{}
+	v := s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)]
+	if v == "" {
+		return secrets, nil
+	}
+	precedence := map[string]int{}
+	for _, entry := range strings.Split(v, ",") {
+		pattern, secret, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			pattern, secret = "", pattern
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("service %s/%s has empty tls secret for %s", s.Namespace, s.Name, entry)
+		}
+		matched := false
+		for _, host := range hosts {
+			rank := 0
+			switch {
+			case pattern == host:
+				rank = 3
+			case pattern != "" && hostMatches(pattern, host):
+				rank = 2
+			case pattern == "":
+				rank = 1
+			default:
+				continue
+			}
+			matched = true
+			if rank > precedence[host] {
+				secrets[host], precedence[host] = secret, rank
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("service %s/%s has tls secret %s for undeclared host %s", s.Namespace, s.Name, secret, pattern)
+		}
+	}
+	return secrets, nil
+}
+
+// buildIngressTLS groups hosts sharing a secret into a single tls entry. Hosts get existing
+// secrets when given, or a secret named after the template otherwise.
+func (h *Handler) buildIngressTLS(name string, namespace string, hosts []string, secrets map[string]string) []networking.IngressTLS {
+	var tls []networking.IngressTLS
+	for _, host := range hosts {
+		secret, ok := secrets[host]
+		if !ok {
+			secret = h.getTLSSecretName(name, namespace, host)
+		}
+		j := slices.IndexFunc(tls, func(t networking.IngressTLS) bool { return t.SecretName == secret })
+		if j < 0 {
+			j = len(tls)
+			tls = append(tls, networking.IngressTLS{SecretName: secret})
+		}
+		tls[j].Hosts = append(tls[j].Hosts, host)
+	}
+	return tls
+}
+
+// unissuedTLSHost returns a host left without a certificate when existing secrets are mixed with
+// secrets issued through cert-manager annotations, which are dropped once existing secrets are set.
+// Certificates managed by the bot are issued per secret, so they allow the mix.
+func unissuedTLSHost(hosts []string, secrets map[string]string, enableTLS bool, issuer string) string {
+	if !enableTLS || issuer == "" || len(secrets) == 0 || viper.GetBool(config.CertManagerCertificates) {
+		return ""
+	}
+	for _, host := range hosts {
+		if _, ok := secrets[host]; !ok {
+			return host
+		}
+	}
+	return ""
+}
+
+// setIngressTLSSecrets regroups the tls entries of every host with the existing secrets declared by
+// the services. Existing secrets are listed on the ingress so the bot never requests certificates
+// for them, and cert-manager annotations are dropped, as cert-manager would otherwise take over
+// every secret.
+func (h *Handler) setIngressTLSSecrets(i *networking.Ingress, secrets map[string]string) {
+	if len(i.Spec.TLS) == 0 {
+		return
+	}
+	var hosts []string
+	for _, rule := range i.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+	}
+	i.Spec.TLS = h.buildIngressTLS(i.Name, i.Namespace, hosts, secrets)
+	if len(secrets) == 0 {
+		return
+	}
+
+	var existing []string
+	for _, secret := range secrets {
+		if !slices.Contains(existing, secret) {
+			existing = append(existing, secret)
+		}
+	}
+	sort.Strings(existing)
+	i.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = strings.Join(existing, ",")
+	for _, key := range issuerAnnotations {
+		if _, ok := i.Annotations[key]; ok {
+			h.logger.Info(fmt.Sprintf("removing cert-manager annotations from ingress %s/%s with existing tls secrets", i.Namespace, i.Name))
+			delete(i.Annotations, key)
+		}
+	}
+}
+
+// isExistingTLSSecret reports whether a tls secret of the ingress was declared by its services
+func isExistingTLSSecret(i *networking.Ingress, secret string) bool {
+	v := i.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)]
+	return v != "" && slices.Contains(strings.Split(v, ","), secret)
+}
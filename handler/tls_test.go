@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/record"
+	"testing"
+)
+
+func Test_TLS(t *testing.T) {
+
+	config.Load()
+
+	service.Labels[viper.GetString(config.ResourceLabelKey)] = viper.GetString(config.ResourceLabelValue)
+	service.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com"
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	h := newTestHandler(t, ctx, logger)
+
+	t.Run("get tls secret name", func(t *testing.T) {
+		assert.Equal(t, "www-example-com-tls", h.getTLSSecretName("www-example-com", "default", "www.example.com"))
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Namespace }}-{{ .Host }}")
+		defer viper.Set(config.IngressTLSSecretTemplate, "{{ .Name }}-tls")
+		assert.Equal(t, "default-www.example.com", h.getTLSSecretName("www-example-com", "default", "www.example.com"))
+	})
+	t.Run("get tls secret name for wildcard host", func(t *testing.T) {
+		assert.Equal(t, "example.com-wildcard-tls", h.getTLSSecretName("www-example-com", "default", "*.example.com"))
+		viper.Set(config.IngressWildcardTLSTemplate, "{{ .Host }}")
+		defer viper.Set(config.IngressWildcardTLSTemplate, "{{ .Domain }}-wildcard-tls")
+		assert.Equal(t, "wildcard-example-com", h.getTLSSecretName("www-example-com", "default", "*.example.com"))
+	})
+	t.Run("get tls secret name with invalid template", func(t *testing.T) {
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Missing }}")
+		defer viper.Set(config.IngressTLSSecretTemplate, "{{ .Name }}-tls")
+		assert.Equal(t, "www-example-com-tls", h.getTLSSecretName("www-example-com", "default", "www.example.com"))
+		assert.Len(t, observedLogs.FilterMessageSnippet("error rendering tls secret name").All(), 1)
+	})
+
+	t.Run("host matches", func(t *testing.T) {
+		assert.True(t, hostMatches("www.example.com", "www.example.com"))
+		assert.True(t, hostMatches("*.example.com", "www.example.com"))
+		assert.False(t, hostMatches("*.example.com", "example.com"))
+		assert.False(t, hostMatches("*.example.com", "www.api.example.com"))
+		assert.False(t, hostMatches("*example.com", "wwwexample.com"))
+	})
+
+	t.Run("get service tls secrets", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,api.example.com,example.org"
+		l, err := h.getServiceTLSSecrets(s)
+		assert.NoError(t, err)
+		assert.Len(t, l, 0)
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "default-cert, *.example.com=wildcard-example-com, api.example.com=api-cert"
+		l, err = h.getServiceTLSSecrets(s)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"www.example.com": "wildcard-example-com", "api.example.com": "api-cert", "example.org": "default-cert"}, l)
+	})
+	t.Run("get service tls secrets for undeclared host", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "*.example.org=wildcard-example-org"
+		_, err := h.getServiceTLSSecrets(s)
+		assert.Error(t, err)
+	})
+	t.Run("get service tls secrets with empty secret", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "www.example.com="
+		_, err := h.getServiceTLSSecrets(s)
+		assert.Error(t, err)
+	})
+
+	t.Run("build ingress tls", func(t *testing.T) {
+		tls := h.buildIngressTLS("www-example-com", "default", []string{"www.example.com", "api.example.com", "example.org"},
+			map[string]string{"www.example.com": "wildcard-example-com", "api.example.com": "wildcard-example-com"})
+		assert.Equal(t, []networking.IngressTLS{
+			{Hosts: []string{"www.example.com", "api.example.com"}, SecretName: "wildcard-example-com"},
+			{Hosts: []string{"example.org"}, SecretName: "www-example-com-tls"},
+		}, tls)
+	})
+	t.Run("build ingress tls with host template", func(t *testing.T) {
+		viper.Set(config.IngressTLSSecretTemplate, "{{ .Host }}-tls")
+		defer viper.Set(config.IngressTLSSecretTemplate, "{{ .Name }}-tls")
+		tls := h.buildIngressTLS("www-example-com", "default", []string{"www.example.com", "example.org"}, nil)
+		assert.Len(t, tls, 2)
+	})
+
+	t.Run("set ingress tls secrets", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com", "example.org"}, "", true)
+		i.Annotations["cert-manager.io/cluster-issuer"] = "letsencrypt"
+		h.setIngressTLSSecrets(i, map[string]string{"www.example.com": "wildcard-example-com"})
+		assert.Len(t, i.Spec.TLS, 2)
+		assert.NotContains(t, i.Annotations, "cert-manager.io/cluster-issuer")
+		assert.True(t, isExistingTLSSecret(i, "wildcard-example-com"))
+		assert.False(t, isExistingTLSSecret(i, "www-example-com-tls"))
+	})
+	t.Run("set ingress tls secrets without tls", func(t *testing.T) {
+		i := h.buildIngress("www-example-com", "default", []string{"www.example.com"}, "", false)
+		h.setIngressTLSSecrets(i, map[string]string{"www.example.com": "wildcard-example-com"})
+		assert.Len(t, i.Spec.TLS, 0)
+		assert.False(t, isExistingTLSSecret(i, "wildcard-example-com"))
+	})
+
+	t.Run("build desired ingresses with existing tls secret", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,www.example.org"
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "*.example.com=wildcard-example-com"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.Equal(t, []networking.IngressTLS{
+			{Hosts: []string{"www.example.com"}, SecretName: "wildcard-example-com"},
+			{Hosts: []string{"www.example.org"}, SecretName: "www-example-com-tls"},
+		}, l["default/www-example-com"].Spec.TLS)
+	})
+	t.Run("build desired ingresses with existing tls secret and issuer", func(t *testing.T) {
+		viper.Set(config.CertManagerIssuer, "letsencrypt")
+		defer viper.Set(config.CertManagerIssuer, "")
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,www.example.org"
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "*.example.com=wildcard-example-com"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.NotContains(t, l, "default/www-example-com")
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events,
+			"Warning IngressConflict service default/service declaring tls secrets for ingress default/www-example-com, leaving host www.example.org without certificate")
+		viper.Set(config.CertManagerCertificates, true)
+		defer viper.Set(config.CertManagerCertificates, false)
+		l, err = h.buildDesiredIngresses()
+		assert.NoError(t, err)
+		assert.NotContains(t, l["default/www-example-com"].Annotations, "cert-manager.io/cluster-issuer")
+		assert.Len(t, h.buildCertificates(l["default/www-example-com"]), 1)
+	})
+	t.Run("build desired ingresses with grouped hosts missing tls secret", func(t *testing.T) {
+		viper.Set(config.CertManagerIssuer, "letsencrypt")
+		defer viper.Set(config.CertManagerIssuer, "")
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "wildcard-example-com"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressHostAnnotation)] = "www.example.com,www.example.org"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Equal(t, []networking.IngressTLS{{Hosts: []string{"www.example.com"}, SecretName: "wildcard-example-com"}}, l["default/www-example-com"].Spec.TLS)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring tls secrets for ingress default/www-example-com, leaving host www.example.org without certificate")
+	})
+	t.Run("build desired ingresses with tls secret conflict", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "wildcard-example-com"
+		s2 := service.DeepCopy()
+		s2.Name = "service2"
+		s2.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "www-example-com"
+		h := newTestHandler(t, ctx, logger, s, s2)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		l, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Equal(t, "wildcard-example-com", l["default/www-example-com"].Spec.TLS[0].SecretName)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning IngressConflict service default/service2 declaring tls secret www-example-com")
+	})
+	t.Run("build desired ingresses with invalid tls secret", func(t *testing.T) {
+		s := service.DeepCopy()
+		s.Annotations[viper.GetString(config.IngressTLSSecretAnnotation)] = "www.example.org=www-example-org"
+		h := newTestHandler(t, ctx, logger, s)
+		h.services, _ = h.fetchServices("default/www-example-com")
+		_, err := h.buildDesiredIngresses()
+		assert.Error(t, err)
+		assert.Contains(t, <-h.recorder.(*record.FakeRecorder).Events, "Warning InvalidTLSSecret")
+	})
+
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type state struct {
+	mu            sync.Mutex
+	clientSet     bool
+	started       time.Time
+	busy          bool
+	settled       bool
+	lastTick      time.Time
+	lastReconcile time.Time
+}
+
+var s = &state{}
+
+func SetClientSet() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientSet = true
+}
+
+func Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = time.Now()
+	s.lastTick = s.started
+}
+
+// Busy marks the loop as working on an item
+func Busy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = true
+	s.lastTick = time.Now()
+}
+
+// Idle marks the loop as waiting on an empty queue, settled when no item is left to retry
+func Idle(settled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = false
+	s.settled = settled
+	s.lastTick = time.Now()
+}
+
+func Reconciled() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastTick = time.Now()
+	s.lastReconcile = s.lastTick
+}
+
+func reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientSet = false
+	s.started = time.Time{}
+	s.busy = false
+	s.settled = false
+	s.lastTick = time.Time{}
+	s.lastReconcile = time.Time{}
+}
+
+func window() time.Duration {
+	return viper.GetDuration(config.HealthIntervalMultiplier) * viper.GetDuration(config.CheckInterval) * time.Second
+}
+
+// checkLiveness fails when a started loop has been stuck on an item. An idle loop, or a loop that
+// never started (e.g. a leader election standby), is considered alive.
+func checkLiveness() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := window(); w > 0 && !s.started.IsZero() && s.busy && time.Since(s.lastTick) > w {
+		return fmt.Errorf("reconciliation loop last ticked at %s", s.lastTick.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkReadiness requires a client set and, once the loop has started, a recent successful reconcile,
+// unless the loop is idle with nothing left to retry
+func checkReadiness() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.clientSet {
+		return fmt.Errorf("kubernetes client set not ready")
+	}
+	if s.started.IsZero() || (!s.busy && s.settled) {
+		return nil
+	}
+	if s.lastReconcile.IsZero() {
+		return fmt.Errorf("no successful reconcile yet")
+	}
+	if w := window(); w > 0 && time.Since(s.lastReconcile) > w {
+		return fmt.Errorf("last successful reconcile at %s", s.lastReconcile.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func Liveness(w http.ResponseWriter, _ *http.Request) {
+	respond(w, checkLiveness())
+}
+
+func Readiness(w http.ResponseWriter, _ *http.Request) {
+	respond(w, checkReadiness())
+}
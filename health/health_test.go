@@ -0,0 +1,101 @@
+package health
+
+import (
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(f http.HandlerFunc) int {
+	rec := httptest.NewRecorder()
+	f(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code
+}
+
+func Test_Health(t *testing.T) {
+
+	config.Load()
+
+	t.Run("liveness before start", func(t *testing.T) {
+		reset()
+		assert.Equal(t, http.StatusOK, probe(Liveness))
+	})
+	t.Run("liveness after tick", func(t *testing.T) {
+		reset()
+		Start()
+		Busy()
+		assert.Equal(t, http.StatusOK, probe(Liveness))
+	})
+	t.Run("liveness with stalled loop", func(t *testing.T) {
+		reset()
+		Start()
+		Busy()
+		s.lastTick = time.Now().Add(-window() - time.Second)
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Liveness))
+	})
+	t.Run("liveness with idle loop", func(t *testing.T) {
+		reset()
+		Start()
+		Idle(false)
+		s.lastTick = time.Now().Add(-window() - time.Second)
+		assert.Equal(t, http.StatusOK, probe(Liveness))
+	})
+
+	t.Run("readiness without client set", func(t *testing.T) {
+		reset()
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Readiness))
+	})
+	t.Run("readiness before start", func(t *testing.T) {
+		reset()
+		SetClientSet()
+		assert.Equal(t, http.StatusOK, probe(Readiness))
+	})
+	t.Run("readiness before first reconcile", func(t *testing.T) {
+		reset()
+		SetClientSet()
+		Start()
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Readiness))
+	})
+	t.Run("readiness after reconcile", func(t *testing.T) {
+		reset()
+		SetClientSet()
+		Start()
+		Reconciled()
+		assert.Equal(t, http.StatusOK, probe(Readiness))
+	})
+	t.Run("readiness with stale reconcile", func(t *testing.T) {
+		reset()
+		SetClientSet()
+		Start()
+		Reconciled()
+		s.lastReconcile = time.Now().Add(-window() - time.Second)
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Readiness))
+	})
+	t.Run("readiness with settled loop", func(t *testing.T) {
+		reset()
+		SetClientSet()
+		Start()
+		Idle(true)
+		assert.Equal(t, http.StatusOK, probe(Readiness))
+		Idle(false)
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Readiness))
+		Idle(true)
+		Busy()
+		assert.Equal(t, http.StatusServiceUnavailable, probe(Readiness))
+	})
+	t.Run("readiness with checks disabled", func(t *testing.T) {
+		reset()
+		viper.Set(config.CheckInterval, 0)
+		defer viper.Set(config.CheckInterval, 30)
+		SetClientSet()
+		Start()
+		Reconciled()
+		s.lastReconcile = time.Now().Add(-time.Hour)
+		assert.Equal(t, http.StatusOK, probe(Readiness))
+	})
+
+}
@@ -0,0 +1,66 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"time"
+)
+
+// leaseName suffixes the lease with the instance name, so every instance elects its own leader
+func leaseName() string {
+	if instance := viper.GetString(config.InstanceName); instance != "" {
+		return fmt.Sprintf("%s-%s", viper.GetString(config.LeaseName), instance)
+	}
+	return viper.GetString(config.LeaseName)
+}
+
+func RunLeaderElection(ctx context.Context, logger *zap.Logger, run func(ctx context.Context)) error {
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting leader election identity: %v", err)
+	}
+
+	// The lease is released as soon as ctx is cancelled, so a standby can take over right away
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: meta.ObjectMeta{
+				Name:      leaseName(),
+				Namespace: viper.GetString(config.LeaseNamespace),
+			},
+			Client:     ClientSet.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   viper.GetDuration(config.LeaseDuration) * time.Second,
+		RenewDeadline:   viper.GetDuration(config.LeaseRenewDeadline) * time.Second,
+		RetryPeriod:     viper.GetDuration(config.LeaseRetryPeriod) * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info(fmt.Sprintf("started leading as %s", identity))
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				logger.Info(fmt.Sprintf("stopped leading as %s", identity))
+			},
+			OnNewLeader: func(id string) {
+				if id != identity {
+					logger.Info(fmt.Sprintf("current leader is %s", id))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating leader elector: %v", err)
+	}
+
+	le.Run(ctx)
+	return nil
+}
@@ -0,0 +1,57 @@
+package kube
+
+import (
+	"context"
+	"github.com/ptonini/ingress-bot/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func Test_Election(t *testing.T) {
+
+	config.Load()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+	observedZapCore, _ := observer.New(zap.DebugLevel)
+	logger := zap.New(observedZapCore)
+
+	t.Run("run leader election", func(t *testing.T) {
+		_ = GetClientSet(ctx, logger)
+		ctx, cancel := context.WithCancel(ctx)
+		started := make(chan struct{})
+		go func() {
+			<-started
+			cancel()
+		}()
+		err := RunLeaderElection(ctx, logger, func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		})
+		assert.NoError(t, err)
+		lease, err := ClientSet.CoordinationV1().Leases(viper.GetString(config.LeaseNamespace)).Get(context.Background(), viper.GetString(config.LeaseName), meta.GetOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, *lease.Spec.HolderIdentity)
+	})
+
+	t.Run("lease name", func(t *testing.T) {
+		assert.Equal(t, "ingress-bot", leaseName())
+		viper.Set(config.InstanceName, "internal")
+		defer viper.Set(config.InstanceName, "")
+		assert.Equal(t, "ingress-bot-internal", leaseName())
+	})
+
+	t.Run("run leader election with invalid durations", func(t *testing.T) {
+		_ = GetClientSet(ctx, logger)
+		viper.Set(config.LeaseRenewDeadline, 30)
+		defer viper.Set(config.LeaseRenewDeadline, 10)
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		assert.Error(t, RunLeaderElection(ctx, logger, func(ctx context.Context) {}))
+	})
+
+}
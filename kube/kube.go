@@ -7,12 +7,19 @@ import (
 	"github.com/ptonini/ingress-bot/config"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	gateway "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+	gatewayFake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/fake"
+	gatewayScheme "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/scheme"
 	"sync"
 )
 
@@ -20,36 +27,67 @@ var lock = &sync.Mutex{}
 
 var ClientSet kubernetes.Interface
 
-func createClientSet(ctx context.Context, logger *zap.Logger) (kubernetes.Interface, error) {
+var GatewayClientSet gateway.Interface
+
+// DynamicClient serves custom resources without vendored types, like cert-manager certificates
+var DynamicClient dynamic.Interface
+
+var CertificateResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+func createClientSet(ctx context.Context, logger *zap.Logger) (kubernetes.Interface, gateway.Interface, dynamic.Interface, error) {
 	var cs kubernetes.Interface
+	var gcs gateway.Interface
+	var dc dynamic.Interface
 	var cfg *rest.Config
 	var err error
 	t := viper.GetString(config.ContextTestingKey)
 	f := viper.GetString(config.ContextFakeObjectsKey)
 	klog.SetLogger(zapr.NewLogger(logger))
 	if ctx.Value(t) != nil && ctx.Value(t).(bool) {
+		// Gateway API and unstructured objects are served by their own fake clients
 		var objList []runtime.Object
+		var gatewayObjList []runtime.Object
+		var dynamicObjList []runtime.Object
 		if ctx.Value(f) != nil {
-			objList = ctx.Value(f).([]runtime.Object)
+			for _, o := range ctx.Value(f).([]runtime.Object) {
+				if _, ok := o.(*unstructured.Unstructured); ok {
+					dynamicObjList = append(dynamicObjList, o)
+				} else if _, _, err := gatewayScheme.Scheme.ObjectKinds(o); err == nil {
+					gatewayObjList = append(gatewayObjList, o)
+				} else {
+					objList = append(objList, o)
+				}
+			}
 		}
 		cs = fake.NewSimpleClientset(objList...)
+		gcs = gatewayFake.NewSimpleClientset(gatewayObjList...)
+		dc = dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{CertificateResource: "CertificateList"}, dynamicObjList...)
 	} else {
 		cfg, err = rest.InClusterConfig()
 		if err != nil {
 			cfg, err = clientcmd.BuildConfigFromFlags("", viper.GetString(config.KubeconfigPath))
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error loading kubernetes config: %v", err)
+			return nil, nil, nil, fmt.Errorf("error loading kubernetes config: %v", err)
 		}
 		cs, err = kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		gcs, err = gateway.NewForConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dc, err = dynamic.NewForConfig(cfg)
 	}
-	return cs, err
+	return cs, gcs, dc, err
 }
 
 func GetClientSet(ctx context.Context, logger *zap.Logger) error {
 	var err error
 	lock.Lock()
 	defer lock.Unlock()
-	ClientSet, err = createClientSet(ctx, logger)
+	ClientSet, GatewayClientSet, DynamicClient, err = createClientSet(ctx, logger)
 	return err
 }
@@ -7,8 +7,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest/observer"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"os"
+	gatewayApi "sigs.k8s.io/gateway-api/apis/v1"
 	"testing"
 )
 
@@ -29,30 +33,58 @@ func Test_Kube(t *testing.T) {
 	_, _ = kubeConfigFile.WriteString(kubeConfigContent)
 
 	t.Run("create client set with no config", func(t *testing.T) {
-		_, err := createClientSet(ctx, logger)
+		_, _, _, err := createClientSet(ctx, logger)
 		assert.Error(t, err)
 	})
 
 	t.Run("create client set with invalid config", func(t *testing.T) {
 		viper.Set(config.KubeconfigPath, "invalid")
-		_, err := createClientSet(ctx, logger)
+		_, _, _, err := createClientSet(ctx, logger)
 		assert.Error(t, err)
 	})
 
 	t.Run("create client set with kubeconfig", func(t *testing.T) {
 		viper.Set(config.KubeconfigPath, kubeConfigFile.Name())
-		_, err := createClientSet(ctx, logger)
+		_, _, _, err := createClientSet(ctx, logger)
 		assert.NoError(t, err)
 	})
 
 	t.Run("create flake client set", func(t *testing.T) {
 		ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
 		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{})
-		_, err := createClientSet(ctx, logger)
+		_, _, _, err := createClientSet(ctx, logger)
 		assert.NoError(t, err)
 
 	})
 
+	t.Run("create fake client sets with gateway objects", func(t *testing.T) {
+		route := &gatewayApi.HTTPRoute{ObjectMeta: meta.ObjectMeta{Name: "route", Namespace: "default"}}
+		ingress := &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ingress", Namespace: "default"}}
+		ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{route, ingress})
+		cs, gcs, _, err := createClientSet(ctx, logger)
+		assert.NoError(t, err)
+		_, err = gcs.GatewayV1().HTTPRoutes("default").Get(ctx, "route", meta.GetOptions{})
+		assert.NoError(t, err)
+		_, err = cs.NetworkingV1().Ingresses("default").Get(ctx, "ingress", meta.GetOptions{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("create fake client sets with unstructured objects", func(t *testing.T) {
+		certificate := &unstructured.Unstructured{}
+		certificate.SetAPIVersion("cert-manager.io/v1")
+		certificate.SetKind("Certificate")
+		certificate.SetName("certificate")
+		certificate.SetNamespace("default")
+		ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
+		ctx = context.WithValue(ctx, viper.GetString(config.ContextFakeObjectsKey), []runtime.Object{certificate})
+		_, _, dc, err := createClientSet(ctx, logger)
+		assert.NoError(t, err)
+		l, err := dc.Resource(CertificateResource).Namespace("default").List(ctx, meta.ListOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, l.Items, 1)
+	})
+
 	t.Run("get client set", func(t *testing.T) {
 		ctx = context.WithValue(ctx, viper.GetString(config.ContextTestingKey), true)
 		assert.NoError(t, GetClientSet(ctx, logger))
@@ -4,10 +4,13 @@ import (
 	"context"
 	"github.com/ptonini/ingress-bot/config"
 	"github.com/ptonini/ingress-bot/handler"
+	"github.com/ptonini/ingress-bot/health"
 	"github.com/ptonini/ingress-bot/kube"
+	"github.com/ptonini/ingress-bot/metrics"
 	"github.com/spf13/viper"
 	"go.elastic.co/ecszap"
 	"go.uber.org/zap"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -19,25 +22,60 @@ func main() {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT)
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	config.Load()
 
 	// Create logger instance
 	logLevel := config.LogLevels[viper.GetString(config.LogLevel)]
 	logger := zap.New(ecszap.NewCore(ecszap.NewDefaultEncoderConfig(), os.Stdout, logLevel), zap.AddCaller())
 	logger.Info("starting service")
+	if err := handler.ValidateConfig(); err != nil {
+		logger.Fatal(err.Error())
+	}
 
 	// Create kubernetes client set
 	err := kube.GetClientSet(ctx, logger)
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
+	health.SetClientSet()
 
-	// Create handler and start reconciliation loop
-	h := handler.Factory(ctx, logger, viper.GetInt64(config.ClientTimeout))
-	go h.ReconciliationLoop()
+	// Start http server for metrics and health checks
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", health.Liveness)
+	mux.HandleFunc("/readyz", health.Readiness)
+	go func() {
+		err := http.ListenAndServe(viper.GetString(config.HTTPAddress), mux)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+	}()
+
+	// Create handler and start watching services and ingresses, as leader when election is enabled
+	run := func(ctx context.Context) {
+		handler.Factory(ctx, logger, viper.GetInt64(config.ClientTimeout)).Run()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if !viper.GetBool(config.LeaderElection) {
+			run(ctx)
+			return
+		}
+		err := kube.RunLeaderElection(ctx, logger, run)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		if ctx.Err() == nil {
+			logger.Fatal("leader election lost")
+		}
+	}()
 
 	<-shutdown
 	logger.Info("stopping service")
+	cancel()
+	<-done
 
 }
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "ingress_bot"
+
+var (
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of ingress reconciliations, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	IngressOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingress_operations_total",
+		Help:      "Number of ingresses created, updated or deleted.",
+	}, []string{"operation"})
+	RouteOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_operations_total",
+		Help:      "Number of gateway routes created, updated or deleted.",
+	}, []string{"operation"})
+	CertificateOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificate_operations_total",
+		Help:      "Number of cert-manager certificates created, updated or deleted.",
+	}, []string{"operation"})
+	APIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed kubernetes API requests, by verb.",
+	}, []string{"verb"})
+	ManagedServices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_services",
+		Help:      "Number of services labeled for the bot.",
+	})
+	ManagedIngresses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_ingresses",
+		Help:      "Number of ingresses labeled as managed by the bot.",
+	})
+	ManagedRoutes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_routes",
+		Help:      "Number of gateway routes labeled as managed by the bot.",
+	})
+	LastSuccessfulReconcile = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_reconcile_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful ingress reconciliation.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ReconcileDuration,
+		IngressOperations,
+		RouteOperations,
+		CertificateOperations,
+		APIErrors,
+		ManagedServices,
+		ManagedIngresses,
+		ManagedRoutes,
+		LastSuccessfulReconcile,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Metrics(t *testing.T) {
+
+	t.Run("serve metrics", func(t *testing.T) {
+		IngressOperations.WithLabelValues("create").Inc()
+		APIErrors.WithLabelValues("update").Inc()
+		ReconcileDuration.WithLabelValues("success").Observe(0.1)
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body, _ := io.ReadAll(rec.Body)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, string(body), `ingress_bot_ingress_operations_total{operation="create"} 1`)
+		assert.Contains(t, string(body), `ingress_bot_api_errors_total{verb="update"} 1`)
+		assert.Contains(t, string(body), `ingress_bot_reconcile_duration_seconds_count{result="success"} 1`)
+		assert.Contains(t, string(body), "ingress_bot_managed_services 0")
+		assert.Contains(t, string(body), "ingress_bot_managed_routes 0")
+		assert.Contains(t, string(body), "ingress_bot_last_successful_reconcile_timestamp_seconds 0")
+	})
+
+}